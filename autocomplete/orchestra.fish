@@ -18,10 +18,28 @@ complete -c orchestra -n "not __fish_seen_subcommand_from (__orchestra_subcomman
 complete -c orchestra -n "__fish_seen_subcommand_from (__orchestra_subcommands)" -l "help" -s "h" --description "show help"
 complete -c orchestra -n "not __fish_seen_subcommand_from (__orchestra_subcommands)" -l "version" -s "v" --description "print the version"
 complete -c orchestra -n "not __fish_seen_subcommand_from (__orchestra_subcommands)" -l "config" -r -F --description "specify a different config file to use"
+complete -c orchestra -n "not __fish_seen_subcommand_from (__orchestra_subcommands)" -l "lock-timeout" -x --description "how long to wait for a locked service"
 
 complete -c orchestra -n "not __fish_seen_subcommand_from (__orchestra_subcommands)" -a "$(__orchestra_subcommands)"
 complete -c orchestra -n "__fish_seen_subcommand_from (__orchestra_subcommands)" -a "(__orchestra_targets)"
 complete -c orchestra -n "__fish_seen_subcommand_from start restart" -l "attach" -s "a" --description "attach to services output after start"
 complete -c orchestra -n "__fish_seen_subcommand_from start restart" -l "logs" -s "l" --description "start logging after start"
+complete -c orchestra -n "__fish_seen_subcommand_from start restart" -l "supervise" --description "restart the services when they exit"
+complete -c orchestra -n "__fish_seen_subcommand_from start up" -l "with-deps" --description "also start the dependencies of the services"
+complete -c orchestra -n "__fish_seen_subcommand_from start up" -l "scale" -r --description "number of instances of a service, as service=N"
+complete -c orchestra -n "__fish_seen_subcommand_from start up" -l "no-vendors" --description "don't start the vendors before the services"
+complete -c orchestra -n "__fish_seen_subcommand_from start" -l "kill-strays" --description "stop unmanaged service processes before starting"
 complete -c orchestra -n "__fish_seen_subcommand_from test" -l "race" -s "r" --description "enable data race detection"
 complete -c orchestra -n "__fish_seen_subcommand_from test" -l "verbose" -s "v" --description "log all tests as they are run"
+complete -c orchestra -n "__fish_seen_subcommand_from wait" -l "timeout" -r --description "how long to wait for a service to be running"
+complete -c orchestra -n "__fish_seen_subcommand_from watch" -l "test" --description "run the tests before restarting"
+complete -c orchestra -n "__fish_seen_subcommand_from watch" -l "debounce" -r --description "how long to wait for changes to settle"
+complete -c orchestra -n "__fish_seen_subcommand_from vendors; and not __fish_seen_subcommand_from up down ps" -a "up down ps"
+complete -c orchestra -n "__fish_seen_subcommand_from vendors; and __fish_seen_subcommand_from down" -l "timeout" -r --description "how long to wait for a vendor to stop"
+complete -c orchestra -n "__fish_seen_subcommand_from signal" -a "SIGHUP SIGINT SIGQUIT SIGTERM SIGUSR1 SIGUSR2"
+complete -c orchestra -n "__fish_seen_subcommand_from up" -l "keep-going" --description "keep the other services running when a service exits"
+complete -c orchestra -n "__fish_seen_subcommand_from export" -l "format" -x -a "sh fish dotenv json direnv procfile systemd launchd compose k8s" --description "output format"
+complete -c orchestra -n "__fish_seen_subcommand_from export" -l "diff" --description "only the variables orchestra adds"
+complete -c orchestra -n "__fish_seen_subcommand_from import; and not __fish_seen_subcommand_from procfile" -a "procfile"
+complete -c orchestra -n "__fish_seen_subcommand_from import; and __fish_seen_subcommand_from procfile" -l "force" --description "overwrite the existing services"
+complete -c orchestra -n "__fish_seen_subcommand_from export" -l "out" -r -a "(__fish_complete_directories)" --description "directory to write the export to"
@@ -0,0 +1,117 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"github.com/wsxiaoys/terminal"
+
+	"github.com/tifo/orchestra/services"
+)
+
+var AdoptCommand = &cli.Command{
+	Name:         "adopt",
+	Usage:        "Takes over service processes started outside of orchestra",
+	Action:       BeforeAfterWrapper(AdoptAction),
+	BashComplete: ServicesBashComplete,
+}
+
+// AdoptAction writes the pid files of the unmanaged processes of every
+// service (or the specified ones), so that orchestra manages them from now
+// on. Each process takes the first instance that is not running.
+func AdoptAction(c *cli.Context) error {
+	for _, service := range services.Sort(FilterServices(c)) {
+		spacing := strings.Repeat(" ", services.MaxServiceNameLength+2-len(service.Name))
+		unlock, err := lockService(c, service)
+		if err != nil {
+			appendError(err)
+			terminal.Stdout.Colorf("%s%s| @{r} error: @{|}%v\n", service.Name, spacing, err)
+			continue
+		}
+		adopt(service)
+		unlock()
+	}
+	return nil
+}
+
+func adopt(service *services.Service) {
+	spacing := strings.Repeat(" ", services.MaxServiceNameLength+2-len(service.Name))
+	slot := 1
+	for _, stray := range service.Strays(managedPids(service)) {
+		instance := freeInstance(service, &slot)
+		if instance == nil {
+			err := fmt.Errorf("No free instance of %s to adopt pid %d", service.Name, stray.Pid)
+			appendError(err)
+			terminal.Stdout.Colorf("%s%s| @{r} error: @{|}%v\n", service.Name, spacing, err)
+			return
+		}
+		instanceSpacing := strings.Repeat(" ", services.MaxServiceNameLength+2-len(instance.Name))
+		if err := services.WriteProcessState(instance.PidFilePath, stray.Pid, stray.Exe); err != nil {
+			appendError(err)
+			terminal.Stdout.Colorf("%s%s| @{r} error: @{|}%v\n", instance.Name, instanceSpacing, err)
+			continue
+		}
+		terminal.Stdout.Colorf("%s%s| @{g} adopted@{|} %d  %s\n", instance.Name, instanceSpacing, stray.Pid, stray.Command)
+	}
+}
+
+// freeInstance returns the first instance from slot that is not running
+func freeInstance(service *services.Service, slot *int) *services.Service {
+	if len(service.OnlyInstances) > 0 {
+		instances := service.Instances(1)
+		for *slot <= len(instances) {
+			instance := instances[*slot-1]
+			*slot++
+			if instance.Process == nil {
+				return instance
+			}
+		}
+		return nil
+	}
+	for ; ; *slot++ {
+		instances := service.Instances(*slot)
+		if instance := instances[len(instances)-1]; instance.Process == nil {
+			*slot++
+			return instance
+		}
+	}
+}
+
+// managedPids returns the processes orchestra knows about for a service:
+// every instance, its children and its supervisor
+func managedPids(service *services.Service) map[int]bool {
+	managed := make(map[int]bool)
+	for _, instance := range service.Instances(service.InstanceCount()) {
+		if instance.Process != nil {
+			managed[instance.Process.Pid] = true
+			for _, child := range instance.Children() {
+				managed[child.Pid] = true
+			}
+		}
+		if instance.Supervisor != nil {
+			managed[instance.Supervisor.Pid] = true
+		}
+	}
+	return managed
+}
+
+// handleStrays warns about the unmanaged processes of a service, or stops
+// them when killStrays is set
+func handleStrays(service *services.Service, killStrays bool) {
+	spacing := strings.Repeat(" ", services.MaxServiceNameLength+2-len(service.Name))
+	strays := service.Strays(managedPids(service))
+	if len(strays) > 0 && !killStrays {
+		terminal.Stdout.Colorf("%s%s| @{y} warning: @{|}%d unmanaged process(es) running, use --kill-strays or orchestra adopt\n", service.Name, spacing, len(strays))
+		return
+	}
+	for _, stray := range strays {
+		how, err := stopProcess(stray.Pid, service.StopSignal, service.StopTimeout)
+		if err != nil {
+			appendError(err)
+			terminal.Stdout.Colorf("%s%s| @{r} error: @{|}%v\n", service.Name, spacing, err)
+			continue
+		}
+		terminal.Stdout.Colorf("%s%s| @{r} killed unmanaged@{|} %d %s\n", service.Name, spacing, stray.Pid, how)
+	}
+}
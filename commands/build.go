@@ -39,12 +39,22 @@ func BuildAction(c *cli.Context) error {
 func buildService(c *cli.Context, service *services.Service) {
 	spacing := strings.Repeat(" ", services.MaxServiceNameLength+2-len(service.Name))
 
-	cmd := exec.Command("nice", "-n", niceness, "go", "build", "-v")
-	cmd.Dir = service.Path
 	output := new(bytes.Buffer)
-	cmd.Stdout = output
-	cmd.Stderr = output
-	if cmd.Run() != nil {
+	var err error
+	if service.Type == services.TypeExec {
+		if service.BuildCommand == "" {
+			terminal.Stdout.Colorf("%s%s| @{y} skipped@{|} (exec service without a build command)\n", service.Name, spacing)
+			return
+		}
+		err = runServiceCommand(c, service, service.BuildCommand, output)
+	} else {
+		cmd := exec.Command("nice", "-n", niceness, "go", "build", "-v")
+		cmd.Dir = service.Path
+		cmd.Stdout = output
+		cmd.Stderr = output
+		err = cmd.Run()
+	}
+	if err != nil {
 		outputStr := output.String()
 		appendError(fmt.Errorf("Failed to build service %s\n%s", service.Name, outputStr))
 		terminal.Stdout.Colorf("%s%s| @{r} error: @{|}Failed to build: %s\n", service.Name, spacing, outputStr)
@@ -0,0 +1,228 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/tifo/orchestra/config"
+	"github.com/tifo/orchestra/services"
+	"github.com/tifo/orchestra/vendors"
+)
+
+// composeFile is a docker-compose.yml, maps are written sorted by key so
+// that the output is stable
+type composeFile struct {
+	Name     string                    `yaml:"name"`
+	Services map[string]composeService `yaml:"services"`
+}
+
+type composeService struct {
+	Image           string            `yaml:"image"`
+	Build           string            `yaml:"build,omitempty"`
+	Command         []string          `yaml:"command,omitempty"`
+	Environment     map[string]string `yaml:"environment,omitempty"`
+	Ports           []string          `yaml:"ports,omitempty"`
+	Volumes         []string          `yaml:"volumes,omitempty"`
+	DependsOn       []string          `yaml:"depends_on,omitempty"`
+	Labels          map[string]string `yaml:"labels,omitempty"`
+	Restart         string            `yaml:"restart,omitempty"`
+	StopSignal      string            `yaml:"stop_signal,omitempty"`
+	StopGracePeriod string            `yaml:"stop_grace_period,omitempty"`
+	MemLimit        uint64            `yaml:"mem_limit,omitempty"`
+	CPUs            float64           `yaml:"cpus,omitempty"`
+	Ulimits         map[string]uint64 `yaml:"ulimits,omitempty"`
+}
+
+var invalidManifestChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// manifestName turns a name into a lowercase DNS label, as used for images
+// and Kubernetes objects
+func manifestName(name string) string {
+	return strings.Trim(invalidManifestChars.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+// imageName returns the image expected for a service, <project>/<service>
+func imageName(service *services.Service) string {
+	return manifestName(filepath.Base(filepath.Clean(services.ProjectPath))) + "/" + manifestName(service.Name)
+}
+
+// composeServiceName returns the name of a service instance in the compose
+// file, with the stack separator and the index of the instance as dashes
+func composeServiceName(instance *services.Service) string {
+	return strings.NewReplacer("/", "-", "#", "-").Replace(instance.Name)
+}
+
+// manifestCommand returns the command of a service in a container: the
+// command and arguments of exec services, the arguments of Go services whose
+// binary is the entrypoint of the image. Variables are expanded with the
+// environment of the service, like CommandLine does, and the orchestra
+// directories are written relative to the project.
+func manifestCommand(service *services.Service, env []string) []string {
+	vars := envMap(env)
+	expand := func(value string) string {
+		return os.Expand(value, func(name string) string {
+			switch name {
+			case "ORCHESTRA_PROJECT_DIR":
+				return "."
+			case "ORCHESTRA_SERVICE_DIR":
+				rel, _ := filepath.Rel(services.ProjectPath, service.Path)
+				return "./" + rel
+			}
+			return vars[name]
+		})
+	}
+	words := make([]string, 0, len(service.Args)+1)
+	if service.Type == services.TypeExec {
+		words = append(words, expand(service.Command))
+	}
+	for _, arg := range service.Args {
+		words = append(words, expand(arg))
+	}
+	return words
+}
+
+// manifestSource returns the path of orchestra.yml relative to the project,
+// so that the manifests don't depend on where the project is checked out
+func manifestSource() string {
+	if rel, err := filepath.Rel(services.ProjectPath, config.ConfigPath); err == nil {
+		return rel
+	}
+	return filepath.Base(config.ConfigPath)
+}
+
+// manifestLabels returns the labels of the objects of a service
+func manifestLabels(service *services.Service) map[string]string {
+	labels := map[string]string{
+		"orchestra.project": filepath.Base(filepath.Clean(services.ProjectPath)),
+		"orchestra.service": service.Name,
+	}
+	if service.Stack != "" {
+		labels["orchestra.stack"] = service.Stack
+	}
+	return labels
+}
+
+// exportCompose writes a docker-compose.yml running every service instance,
+// and the vendors, from images named after the project and the services.
+// Services are built from their directory when they have a Dockerfile.
+func exportCompose(w io.Writer, svcs map[string]*services.Service) error {
+	compose := composeFile{
+		Name:     manifestName(filepath.Base(filepath.Clean(services.ProjectPath))),
+		Services: make(map[string]composeService),
+	}
+	for _, service := range services.Sort(svcs) {
+		deps := exportedDependencies(service, svcs)
+		for _, instance := range service.Instances(service.Scale) {
+			compose.Services[composeServiceName(instance)] = composeInstance(service, instance, deps)
+		}
+	}
+	for _, vendor := range vendors.Sort(vendors.Registry) {
+		compose.Services[vendor.Name] = composeService{
+			Image:       vendor.Image,
+			Command:     escapeCompose(vendor.Command),
+			Environment: escapeComposeMap(vendor.Env),
+			Ports:       vendor.Ports,
+			Volumes:     vendor.Volumes,
+			Labels: map[string]string{
+				"orchestra.project": filepath.Base(filepath.Clean(services.ProjectPath)),
+				"orchestra.vendor":  vendor.Name,
+			},
+		}
+	}
+
+	b := &bytes.Buffer{}
+	encoder := yaml.NewEncoder(b)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(compose); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "# Generated by orchestra export from %s\n", manifestSource())
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+func composeInstance(service, instance *services.Service, deps []*services.Service) composeService {
+	vars := serviceEnvVars(instance)
+	compose := composeService{
+		Image:       imageName(service),
+		Command:     escapeCompose(manifestCommand(instance, getStartEnvForService(instance))),
+		Environment: escapeComposeMap(vars),
+		Labels:      manifestLabels(service),
+		StopSignal:  services.SignalName(service.StopSignal),
+	}
+	if _, err := os.Stat(filepath.Join(service.Path, "Dockerfile")); err == nil {
+		rel, _ := filepath.Rel(services.ProjectPath, service.Path)
+		compose.Build = "./" + rel
+	}
+	// The ports of the instance, as shifted in its environment
+	for _, name := range sortedPorts(instance.DeclaredPorts) {
+		compose.Ports = append(compose.Ports, fmt.Sprintf("%s:%s", vars[name], vars[name]))
+	}
+	for _, dep := range deps {
+		for _, depInstance := range dep.Instances(dep.Scale) {
+			compose.DependsOn = append(compose.DependsOn, composeServiceName(depInstance))
+		}
+	}
+
+	switch service.Restart.Policy {
+	case services.RestartNever:
+		compose.Restart = "no"
+	case services.RestartAlways:
+		compose.Restart = "always"
+	default:
+		compose.Restart = "on-failure"
+		if service.Restart.MaxRetries > 0 {
+			compose.Restart = fmt.Sprintf("on-failure:%d", service.Restart.MaxRetries)
+		}
+	}
+	if service.StopTimeout > 0 {
+		compose.StopGracePeriod = service.StopTimeout.String()
+	}
+	if r := service.Resources; r != nil {
+		compose.MemLimit = uint64(r.Memory)
+		compose.CPUs = r.CPU
+		if r.NoFile > 0 {
+			compose.Ulimits = map[string]uint64{"nofile": r.NoFile}
+		}
+	}
+	return compose
+}
+
+// sortedPorts returns the names of the declared ports in order
+func sortedPorts(ports map[string]int) []string {
+	names := make(map[string]string, len(ports))
+	for name := range ports {
+		names[name] = ""
+	}
+	return sortedKeys(names)
+}
+
+// escapeCompose escapes the `$` that compose would interpolate
+func escapeCompose(values []string) []string {
+	if len(values) == 0 {
+		return nil
+	}
+	escaped := make([]string, 0, len(values))
+	for _, value := range values {
+		escaped = append(escaped, strings.Replace(value, "$", "$$", -1))
+	}
+	return escaped
+}
+
+func escapeComposeMap(values map[string]string) map[string]string {
+	if len(values) == 0 {
+		return nil
+	}
+	escaped := make(map[string]string, len(values))
+	for key, value := range values {
+		escaped[key] = strings.Replace(value, "$", "$$", -1)
+	}
+	return escaped
+}
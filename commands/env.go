@@ -0,0 +1,118 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"github.com/wsxiaoys/terminal"
+
+	"github.com/tifo/orchestra/config"
+)
+
+var validEnvName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// exportEnv prints the variables orchestra sets for a service, or for all
+// of them without a service, in a format shells and tools can load. They are
+// the ones services are started with, from the start section. With --diff,
+// the whole environment of the service is compared to the current one and
+// only the variables which differ are printed. Errors go to stderr, so that
+// they aren't evaluated.
+func exportEnv(c *cli.Context, format string) {
+	if c.Args().Len() > 1 {
+		err := fmt.Errorf("The %s export takes a single service, got %d", format, c.Args().Len())
+		appendError(err)
+		terminal.Stderr.Colorf("@{r}error: @{|}%v\n", err)
+		return
+	}
+	vars := config.GetEnvVarsForCommandName("start")
+	env := config.GetEnvForCommandName("start")
+	watched := append([]string{config.ConfigPath}, config.GetEnvFilesForCommandName("start")...)
+	if c.Args().Len() == 1 {
+		service := singleService(c.Args().First(), terminal.Stderr)
+		if service == nil {
+			return
+		}
+		vars = serviceEnvVars(service)
+		env = getStartEnvForService(service)
+		watched = append(watched, filepath.Join(service.Path, "service.yml"))
+		watched = append(watched, service.EnvFiles...)
+	}
+
+	if c.Bool("diff") {
+		vars = envMap(env)
+		for name, value := range vars {
+			if current, ok := os.LookupEnv(name); ok && current == value {
+				delete(vars, name)
+			}
+		}
+	}
+	if err := writeEnv(os.Stdout, format, vars, watched); err != nil {
+		appendError(err)
+		terminal.Stderr.Colorf("@{r}error: @{|}%v\n", err)
+	}
+}
+
+// writeEnv writes variables in order, for sh, fish, direnv (which also
+// reloads when the given files change), dotenv files or as a JSON object.
+// Variables which can't be set from a shell are skipped, except in JSON.
+func writeEnv(w io.Writer, format string, vars map[string]string, watched []string) error {
+	if format == "json" {
+		b, err := json.MarshalIndent(vars, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", b)
+		return err
+	}
+
+	out := &strings.Builder{}
+	if format == "direnv" {
+		for _, path := range watched {
+			fmt.Fprintf(out, "watch_file %s\n", posixQuote(path))
+		}
+	}
+	for _, name := range sortedKeys(vars) {
+		if !validEnvName.MatchString(name) {
+			terminal.Stderr.Colorf("@{y}warning: @{|}skipping %s, not a valid variable name\n", name)
+			continue
+		}
+		switch format {
+		case "sh", "direnv":
+			fmt.Fprintf(out, "export %s=%s\n", name, posixQuote(vars[name]))
+		case "fish":
+			fmt.Fprintf(out, "set -gx %s %s\n", name, fishQuote(vars[name]))
+		case "dotenv":
+			fmt.Fprintf(out, "%s=%s\n", name, dotenvQuote(vars[name]))
+		}
+	}
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+// posixQuote single quotes a value for POSIX shells, where nothing is special
+// inside single quotes but the quote itself
+func posixQuote(value string) string {
+	return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+}
+
+// fishQuote single quotes a value for fish, which unescapes \\ and \' inside
+// single quotes
+func fishQuote(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
+
+// dotenvQuote quotes a value for a dotenv file: single quotes keep it as is
+// when possible, otherwise it is double quoted with the newlines, quotes,
+// backslashes and dollars escaped
+func dotenvQuote(value string) string {
+	if !strings.ContainsAny(value, "'\n\r") {
+		return "'" + value + "'"
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "$", `\$`).Replace(value) + `"`
+}
@@ -0,0 +1,77 @@
+package commands
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/tifo/orchestra/config"
+)
+
+var quoteTests = []struct {
+	name   string
+	value  string
+	posix  string
+	fish   string
+	dotenv string
+}{
+	{"empty", "", `''`, `''`, `''`},
+	{"spaces", "two  words ", `'two  words '`, `'two  words '`, `'two  words '`},
+	{"dollar", "$HOME ${USER}", `'$HOME ${USER}'`, `'$HOME ${USER}'`, `'$HOME ${USER}'`},
+	{"backticks", "`id` $(id)", "'`id` $(id)'", "'`id` $(id)'", "'`id` $(id)'"},
+	{"single quote", "it's", `'it'\''s'`, `'it\'s'`, `"it's"`},
+	{"double quotes", `say "hi"`, `'say "hi"'`, `'say "hi"'`, `'say "hi"'`},
+	{"both quotes and a dollar", `it's "$5"`, `'it'\''s "$5"'`, `'it\'s "$5"'`, `"it's \"\$5\""`},
+	{"backslashes", `C:\dir\n \\`, `'C:\dir\n \\'`, `'C:\\dir\\n \\\\'`, `'C:\dir\n \\'`},
+	{"newlines", "first\nsecond\r\n", "'first\nsecond\r\n'", "'first\nsecond\r\n'", `"first\nsecond\r\n"`},
+	{"everything", "a'b\"c\\d$e`f\ng", "'a'\\''b\"c\\d$e`f\ng'", "'a\\'b\"c\\\\d$e`f\ng'", `"a'b\"c\\d\$e` + "`" + `f\ng"`},
+}
+
+func TestPosixQuote(t *testing.T) {
+	for _, tt := range quoteTests {
+		t.Run(tt.name, func(t *testing.T) {
+			quoted := posixQuote(tt.value)
+			if quoted != tt.posix {
+				t.Errorf("posixQuote(%q) = %s, want %s", tt.value, quoted, tt.posix)
+			}
+			// The shell reads the value back unchanged
+			got, err := exec.Command("sh", "-c", "printf %s "+quoted).Output()
+			if err != nil || string(got) != tt.value {
+				t.Errorf("sh read %s as %q, %v, want %q", quoted, got, err, tt.value)
+			}
+		})
+	}
+}
+
+func TestFishQuote(t *testing.T) {
+	fish, _ := exec.LookPath("fish")
+	for _, tt := range quoteTests {
+		t.Run(tt.name, func(t *testing.T) {
+			quoted := fishQuote(tt.value)
+			if quoted != tt.fish {
+				t.Errorf("fishQuote(%q) = %s, want %s", tt.value, quoted, tt.fish)
+			}
+			if fish == "" {
+				return
+			}
+			got, err := exec.Command(fish, "--no-config", "-c", "printf %s "+quoted).Output()
+			if err != nil || string(got) != tt.value {
+				t.Errorf("fish read %s as %q, %v, want %q", quoted, got, err, tt.value)
+			}
+		})
+	}
+}
+
+func TestDotenvQuote(t *testing.T) {
+	for _, tt := range quoteTests {
+		t.Run(tt.name, func(t *testing.T) {
+			quoted := dotenvQuote(tt.value)
+			if quoted != tt.dotenv {
+				t.Errorf("dotenvQuote(%q) = %s, want %s", tt.value, quoted, tt.dotenv)
+			}
+			vars, err := config.ParseDotenv("NAME=" + quoted + "\nNEXT=1\n")
+			if err != nil || vars["NAME"] != tt.value || vars["NEXT"] != "1" {
+				t.Errorf("ParseDotenv read %s as %q, %v, want %q", quoted, vars["NAME"], err, tt.value)
+			}
+		})
+	}
+}
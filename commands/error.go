@@ -1,13 +1,36 @@
 package commands
 
-var errorBucket []error
+import "sync"
+
+var (
+	errorBucket []error
+	errorMutex  sync.Mutex
+	exitCode    int
+)
 
 func appendError(err error) {
 	if err != nil {
+		errorMutex.Lock()
 		errorBucket = append(errorBucket, err)
+		errorMutex.Unlock()
 	}
 }
 
 func HasErrors() bool {
 	return len(errorBucket) > 0
 }
+
+// setExitCode sets the exit code of orchestra, for commands exiting like the
+// program they ran
+func setExitCode(code int) {
+	exitCode = code
+}
+
+// ExitCode returns the exit code set by the command, or 1 if there were
+// errors
+func ExitCode() int {
+	if exitCode == 0 && HasErrors() {
+		return 1
+	}
+	return exitCode
+}
@@ -1,7 +1,10 @@
 package commands
 
 import (
+	"bytes"
 	"fmt"
+	"os"
+	"path/filepath"
 
 	"github.com/urfave/cli/v2"
 	"github.com/wsxiaoys/terminal"
@@ -10,15 +13,83 @@ import (
 )
 
 var ExportCommand = &cli.Command{
-	Name:         "export",
-	Usage:        "Export those *#%&! env vars ",
+	Name:      "export",
+	Usage:     "Export those *#%&! env vars ",
+	ArgsUsage: "[<service> | services...]",
+	Description: "The sh, fish, dotenv, json and direnv formats print the variables of a single service, or the\n" +
+		"global ones without a service. The procfile, systemd, launchd, compose and k8s formats export\n" +
+		"every service, or the specified ones.",
 	Action:       BeforeAfterWrapper(ExportAction),
 	BashComplete: ServicesBashComplete,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "format",
+			Usage: "Output format: sh, fish, dotenv, json, direnv, procfile, systemd, launchd, compose or k8s",
+			Value: "sh",
+		},
+		&cli.BoolFlag{
+			Name:  "diff",
+			Usage: "Only export the variables orchestra adds to the current environment",
+		},
+		&cli.StringFlag{
+			Name:  "out",
+			Usage: "Directory to write the systemd units, launchd agents, docker-compose.yml or k8s.yml to",
+		},
+	},
 }
 
 func ExportAction(c *cli.Context) error {
-	for key, value := range config.GetBaseEnvVars() {
-		terminal.Stdout.Print(fmt.Sprintf("export %s=%s\n", key, value))
+	// Everything is exported with the environment services are started with
+	if err := config.LoadEnvForCommandName("start"); err != nil {
+		appendError(err)
+		terminal.Stderr.Colorf("@{r}error: @{|}%v\n", err)
+		return nil
+	}
+	switch c.String("format") {
+	case "sh", "fish", "dotenv", "json", "direnv":
+		exportEnv(c, c.String("format"))
+	case "procfile":
+		exportProcfile(os.Stdout, FilterServices(c))
+	case "systemd", "launchd":
+		if c.String("out") == "" {
+			err := fmt.Errorf("Missing --out directory for the %s export", c.String("format"))
+			appendError(err)
+			terminal.Stdout.Colorf("@{r}error: @{|}%v\n", err)
+			return nil
+		}
+		if c.String("format") == "systemd" {
+			exportSystemd(FilterServices(c), c.String("out"))
+		} else {
+			exportLaunchd(FilterServices(c), c.String("out"))
+		}
+	case "compose", "k8s":
+		export, file := exportCompose, "docker-compose.yml"
+		if c.String("format") == "k8s" {
+			export, file = exportK8s, "k8s.yml"
+		}
+		if c.String("out") == "" {
+			if err := export(os.Stdout, FilterServices(c)); err != nil {
+				appendError(err)
+				terminal.Stdout.Colorf("@{r}error: @{|}%v\n", err)
+			}
+			return nil
+		}
+		b := &bytes.Buffer{}
+		if err := export(b, FilterServices(c)); err != nil {
+			appendError(err)
+			terminal.Stdout.Colorf("@{r}error: @{|}%v\n", err)
+			return nil
+		}
+		if err := os.MkdirAll(c.String("out"), 0755); err != nil {
+			appendError(err)
+			terminal.Stdout.Colorf("@{r}error: @{|}%v\n", err)
+			return nil
+		}
+		writeUnit(filepath.Join(c.String("out"), file), file, b.String())
+	default:
+		err := fmt.Errorf("Unknown export format %s", c.String("format"))
+		appendError(err)
+		terminal.Stdout.Colorf("@{r}error: @{|}%v\n", err)
 	}
 	return nil
 }
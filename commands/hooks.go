@@ -0,0 +1,78 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/urfave/cli/v2"
+	"github.com/wsxiaoys/terminal"
+
+	"github.com/tifo/orchestra/services"
+)
+
+// runHook runs a hook of the service with its environment, the output is
+// prefixed with the service and hook names
+func runHook(c *cli.Context, service *services.Service, name string) error {
+	if !service.HasHook(name) {
+		return nil
+	}
+	spacing := strings.Repeat(" ", services.MaxServiceNameLength+2-len(service.Name))
+	output := newPrefixWriter(os.Stdout, fmt.Sprintf("%s%s| %s: ", service.Name, spacing, name))
+	defer output.Flush()
+	return service.RunHook(name, GetEnvForService(c, service), output)
+}
+
+// reportHook runs a hook of the service and reports its failure, it tells
+// if the hook succeeded
+func reportHook(c *cli.Context, service *services.Service, name string) bool {
+	if err := runHook(c, service, name); err != nil {
+		appendError(err)
+		spacing := strings.Repeat(" ", services.MaxServiceNameLength+2-len(service.Name))
+		terminal.Stdout.Colorf("%s%s| @{r} error: @{|}%v\n", service.Name, spacing, err)
+		return false
+	}
+	return true
+}
+
+// prefixWriter writes every line with a prefix. Incomplete lines are kept
+// until they are complete, or until Flush.
+type prefixWriter struct {
+	w      io.Writer
+	prefix string
+	mutex  sync.Mutex
+	buf    []byte
+}
+
+func newPrefixWriter(w io.Writer, prefix string) *prefixWriter {
+	return &prefixWriter{w: w, prefix: prefix}
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.buf = append(p.buf, b...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			return len(b), nil
+		}
+		if _, err := fmt.Fprintf(p.w, "%s%s\n", p.prefix, p.buf[:i]); err != nil {
+			return 0, err
+		}
+		p.buf = p.buf[i+1:]
+	}
+}
+
+// Flush writes the incomplete line left, if any
+func (p *prefixWriter) Flush() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if len(p.buf) > 0 {
+		fmt.Fprintf(p.w, "%s%s\n", p.prefix, p.buf)
+		p.buf = nil
+	}
+}
@@ -2,7 +2,10 @@ package commands
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"os"
 	"os/exec"
 	"runtime"
 	"strings"
@@ -25,7 +28,19 @@ func InstallAction(c *cli.Context) error {
 	worker := func(service *services.Service) func() {
 		return func() {
 			spacing := strings.Repeat(" ", services.MaxServiceNameLength+2-len(service.Name))
-			rebuilt, err := installService(service)
+			install := func(service *services.Service) (bool, error) {
+				return installService(service, nil)
+			}
+			if service.Type == services.TypeExec {
+				if service.BuildCommand == "" {
+					terminal.Stdout.Colorf("%s%s| @{y} skipped@{|} (exec service without a build command)\n", service.Name, spacing)
+					return
+				}
+				install = func(service *services.Service) (bool, error) {
+					return buildExecService(c, service, nil)
+				}
+			}
+			rebuilt, err := install(service)
 			if err != nil {
 				appendError(err)
 				terminal.Stdout.Colorf("%s%s| @{r} error: @{|}%v\n", service.Name, spacing, err)
@@ -45,21 +60,80 @@ func InstallAction(c *cli.Context) error {
 	return nil
 }
 
+// prepareService gets a service ready to start: Go services are installed,
+// unless they run with go run which builds them on the fly, and exec services
+// run their build command if they have one. The build output is streamed to
+// output when not nil, and only included in the error otherwise.
+func prepareService(c *cli.Context, service *services.Service, output io.Writer) (bool, error) {
+	switch {
+	case service.Type == services.TypeExec:
+		return buildExecService(c, service, output)
+	case service.GoRun:
+		return false, checkGoRunService(service, output)
+	}
+	return installService(service, output)
+}
+
+// buildOutput returns the buffer collecting the output of a build, and the
+// writer to give to the build: the buffer, also streaming to output if not nil
+func buildOutput(output io.Writer) (*bytes.Buffer, io.Writer) {
+	buffer := bytes.NewBuffer([]byte{})
+	if output == nil {
+		return buffer, buffer
+	}
+	return buffer, io.MultiWriter(buffer, output)
+}
+
+// buildError reports a failed build with its output, unless it was streamed
+func buildError(message string, buffer *bytes.Buffer, output io.Writer) error {
+	if output != nil {
+		return errors.New(message)
+	}
+	return fmt.Errorf("%s\n%s", message, buffer.String())
+}
+
+// checkGoRunService compiles a go run service without installing it, so
+// that compile errors show up before the running instance is stopped
+func checkGoRunService(service *services.Service, output io.Writer) error {
+	buffer, w := buildOutput(output)
+	cmd := exec.Command("nice", "-n", niceness, "go", "build", "-o", os.DevNull, ".")
+	cmd.Dir = service.Path
+	cmd.Stdout = w
+	cmd.Stderr = w
+	if err := cmd.Run(); err != nil {
+		return buildError("Failed to build service "+service.Name, buffer, output)
+	}
+	return nil
+}
+
+// buildExecService runs the build command of an exec service, it tells if
+// there was one
+func buildExecService(c *cli.Context, service *services.Service, output io.Writer) (bool, error) {
+	if service.BuildCommand == "" {
+		return false, nil
+	}
+	buffer, w := buildOutput(output)
+	if err := runServiceCommand(c, service, service.BuildCommand, w); err != nil {
+		return false, buildError("Failed to build service "+service.Name, buffer, output)
+	}
+	return true, nil
+}
+
 // installService runs go install in the service directory
-func installService(service *services.Service) (bool, error) {
+func installService(service *services.Service, output io.Writer) (bool, error) {
 	cmd := exec.Command("nice", "-n", niceness, "go", "install", "-v")
 	cmd.Dir = service.Path
-	output := bytes.NewBuffer([]byte{})
-	cmd.Stdout = output
-	cmd.Stderr = output
+	buffer, w := buildOutput(output)
+	cmd.Stdout = w
+	cmd.Stderr = w
 	err := cmd.Start()
 	if err != nil {
 		return false, err
 	}
 	_ = cmd.Wait()
 	if !cmd.ProcessState.Success() {
-		return false, fmt.Errorf("Failed to install service %s\n%s", service.Name, output.String())
-	} else if output.Len() > 0 {
+		return false, buildError("Failed to install service "+service.Name, buffer, output)
+	} else if buffer.Len() > 0 {
 		return true, nil
 	}
 	return false, nil
@@ -0,0 +1,276 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/tifo/orchestra/services"
+)
+
+// Kubernetes objects, with only the fields orchestra fills in. They are
+// written field by field in the usual order, and maps sorted by key.
+type k8sObject struct {
+	APIVersion string            `yaml:"apiVersion"`
+	Kind       string            `yaml:"kind"`
+	Metadata   k8sMetadata       `yaml:"metadata"`
+	Data       map[string]string `yaml:"data,omitempty"`
+	Spec       interface{}       `yaml:"spec,omitempty"`
+}
+
+type k8sMetadata struct {
+	Name      string            `yaml:"name,omitempty"`
+	Namespace string            `yaml:"namespace,omitempty"`
+	Labels    map[string]string `yaml:"labels,omitempty"`
+}
+
+type k8sDeploymentSpec struct {
+	Replicas int         `yaml:"replicas"`
+	Selector k8sSelector `yaml:"selector"`
+	Template struct {
+		Metadata k8sMetadata `yaml:"metadata"`
+		Spec     k8sPodSpec  `yaml:"spec"`
+	} `yaml:"template"`
+}
+
+type k8sSelector struct {
+	MatchLabels map[string]string `yaml:"matchLabels"`
+}
+
+type k8sPodSpec struct {
+	Containers                    []k8sContainer `yaml:"containers"`
+	TerminationGracePeriodSeconds int            `yaml:"terminationGracePeriodSeconds,omitempty"`
+}
+
+type k8sContainer struct {
+	Name           string             `yaml:"name"`
+	Image          string             `yaml:"image"`
+	Command        []string           `yaml:"command,omitempty"`
+	Args           []string           `yaml:"args,omitempty"`
+	EnvFrom        []k8sEnvFrom       `yaml:"envFrom,omitempty"`
+	Ports          []k8sContainerPort `yaml:"ports,omitempty"`
+	Resources      *k8sResources      `yaml:"resources,omitempty"`
+	ReadinessProbe *k8sProbe          `yaml:"readinessProbe,omitempty"`
+}
+
+type k8sEnvFrom struct {
+	ConfigMapRef struct {
+		Name string `yaml:"name"`
+	} `yaml:"configMapRef"`
+}
+
+type k8sContainerPort struct {
+	Name          string `yaml:"name"`
+	ContainerPort int    `yaml:"containerPort"`
+}
+
+type k8sResources struct {
+	Limits map[string]string `yaml:"limits"`
+}
+
+type k8sProbe struct {
+	HTTPGet *struct {
+		Path   string `yaml:"path"`
+		Port   int    `yaml:"port"`
+		Scheme string `yaml:"scheme,omitempty"`
+	} `yaml:"httpGet,omitempty"`
+	TCPSocket *struct {
+		Port int `yaml:"port"`
+	} `yaml:"tcpSocket,omitempty"`
+	Exec *struct {
+		Command []string `yaml:"command"`
+	} `yaml:"exec,omitempty"`
+	PeriodSeconds    int `yaml:"periodSeconds,omitempty"`
+	TimeoutSeconds   int `yaml:"timeoutSeconds,omitempty"`
+	FailureThreshold int `yaml:"failureThreshold,omitempty"`
+}
+
+type k8sServiceSpec struct {
+	Selector map[string]string `yaml:"selector"`
+	Ports    []k8sServicePort  `yaml:"ports"`
+}
+
+type k8sServicePort struct {
+	Name       string `yaml:"name"`
+	Port       int    `yaml:"port"`
+	TargetPort int    `yaml:"targetPort"`
+}
+
+// exportK8s writes a namespace for every stack, and a ConfigMap with the
+// environment, a Deployment and a Service (when it declares ports) for
+// every service. The objects of a service live in the namespace of its
+// stack and are named after the service within it.
+func exportK8s(w io.Writer, svcs map[string]*services.Service) error {
+	objects := make([]k8sObject, 0)
+	stacks := make(map[string]bool)
+	for _, service := range svcs {
+		if service.Stack != "" {
+			stacks[service.Stack] = true
+		}
+	}
+	stackNames := make([]string, 0, len(stacks))
+	for stack := range stacks {
+		stackNames = append(stackNames, stack)
+	}
+	sort.Strings(stackNames)
+	for _, stack := range stackNames {
+		objects = append(objects, k8sObject{
+			APIVersion: "v1",
+			Kind:       "Namespace",
+			Metadata: k8sMetadata{
+				Name:   manifestName(stack),
+				Labels: map[string]string{"orchestra.project": filepath.Base(filepath.Clean(services.ProjectPath))},
+			},
+		})
+	}
+	for _, service := range services.Sort(svcs) {
+		objects = append(objects, k8sService(service)...)
+	}
+
+	fmt.Fprintf(w, "# Generated by orchestra export from %s\n", manifestSource())
+	for i, object := range objects {
+		b := &bytes.Buffer{}
+		encoder := yaml.NewEncoder(b)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(object); err != nil {
+			return err
+		}
+		if i > 0 {
+			fmt.Fprintf(w, "---\n")
+		}
+		if _, err := w.Write(b.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func k8sService(service *services.Service) []k8sObject {
+	name := manifestName(strings.TrimPrefix(service.Name, service.Stack+"/"))
+	labels := manifestLabels(service)
+	labels["app.kubernetes.io/name"] = name
+	labels["app.kubernetes.io/part-of"] = filepath.Base(filepath.Clean(services.ProjectPath))
+	labels["app.kubernetes.io/managed-by"] = "orchestra"
+	selector := map[string]string{"app.kubernetes.io/name": name}
+	metadata := k8sMetadata{Name: name, Namespace: manifestName(service.Stack), Labels: labels}
+
+	// Replicas share the environment of the first instance, the ports don't
+	// need to be shifted as every pod has its own address
+	env := getStartEnvForService(service)
+	vars := serviceEnvVars(service)
+	delete(vars, "ORCHESTRA_INSTANCE")
+	configMap := k8sObject{APIVersion: "v1", Kind: "ConfigMap", Metadata: metadata, Data: vars}
+	configMap.Metadata.Name = name + "-env"
+
+	container := k8sContainer{Name: name, Image: imageName(service)}
+	if command := manifestCommand(service, env); service.Type == services.TypeExec {
+		container.Command = command
+	} else {
+		container.Args = command
+	}
+	envFrom := k8sEnvFrom{}
+	envFrom.ConfigMapRef.Name = configMap.Metadata.Name
+	container.EnvFrom = []k8sEnvFrom{envFrom}
+	ports := make([]k8sServicePort, 0, len(service.DeclaredPorts))
+	for _, portName := range sortedPorts(service.DeclaredPorts) {
+		port := service.DeclaredPorts[portName]
+		name := manifestName(portName)
+		if len(name) > 15 {
+			name = strings.Trim(name[:15], "-")
+		}
+		container.Ports = append(container.Ports, k8sContainerPort{Name: name, ContainerPort: port})
+		ports = append(ports, k8sServicePort{Name: name, Port: port, TargetPort: port})
+	}
+	if r := service.Resources; r != nil && (r.Memory > 0 || r.CPU > 0) {
+		container.Resources = &k8sResources{Limits: make(map[string]string)}
+		if r.Memory > 0 {
+			container.Resources.Limits["memory"] = strconv.FormatUint(uint64(r.Memory), 10)
+		}
+		if r.CPU > 0 {
+			container.Resources.Limits["cpu"] = fmt.Sprintf("%dm", int(r.CPU*1000+0.5))
+		}
+	}
+	container.ReadinessProbe = k8sReadinessProbe(service.HealthCheck, envMap(env))
+
+	deployment := k8sObject{APIVersion: "apps/v1", Kind: "Deployment", Metadata: metadata}
+	spec := k8sDeploymentSpec{Replicas: service.Scale, Selector: k8sSelector{MatchLabels: selector}}
+	if spec.Replicas < 1 {
+		spec.Replicas = 1
+	}
+	spec.Template.Metadata = k8sMetadata{Labels: labels}
+	spec.Template.Spec.Containers = []k8sContainer{container}
+	spec.Template.Spec.TerminationGracePeriodSeconds = int(service.StopTimeout.Seconds() + 0.5)
+	deployment.Spec = spec
+
+	objects := []k8sObject{configMap, deployment}
+	if len(ports) > 0 {
+		objects = append(objects, k8sObject{
+			APIVersion: "v1",
+			Kind:       "Service",
+			Metadata:   metadata,
+			Spec:       k8sServiceSpec{Selector: selector, Ports: ports},
+		})
+	}
+	return objects
+}
+
+// k8sReadinessProbe turns the http, tcp and exec health checks into a
+// readiness probe, log health checks have no equivalent
+func k8sReadinessProbe(check *services.HealthCheck, vars map[string]string) *k8sProbe {
+	if check == nil {
+		return nil
+	}
+	expand := func(value string) string {
+		return os.Expand(value, func(name string) string { return vars[name] })
+	}
+	probe := &k8sProbe{
+		PeriodSeconds:    int(check.Interval.Seconds() + 0.5),
+		TimeoutSeconds:   int(check.Timeout.Seconds() + 0.5),
+		FailureThreshold: check.Retries,
+	}
+	switch {
+	case check.HTTP != "":
+		u, err := url.Parse(expand(check.HTTP))
+		if err != nil {
+			return nil
+		}
+		// Kubernetes probes plain HTTP on port 80 unless told otherwise
+		scheme, port := "", 80
+		if u.Scheme == "https" {
+			scheme, port = "HTTPS", 443
+		}
+		if explicit, _ := strconv.Atoi(u.Port()); explicit != 0 {
+			port = explicit
+		}
+		probe.HTTPGet = &struct {
+			Path   string `yaml:"path"`
+			Port   int    `yaml:"port"`
+			Scheme string `yaml:"scheme,omitempty"`
+		}{Path: u.RequestURI(), Port: port, Scheme: scheme}
+	case check.TCP != "":
+		_, portValue, err := net.SplitHostPort(expand(check.TCP))
+		port, _ := strconv.Atoi(portValue)
+		if err != nil || port == 0 {
+			return nil
+		}
+		probe.TCPSocket = &struct {
+			Port int `yaml:"port"`
+		}{Port: port}
+	case check.Exec != "":
+		probe.Exec = &struct {
+			Command []string `yaml:"command"`
+		}{Command: []string{"sh", "-c", check.Exec}}
+	default:
+		return nil
+	}
+	return probe
+}
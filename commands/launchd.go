@@ -0,0 +1,87 @@
+package commands
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/wsxiaoys/terminal"
+
+	"github.com/tifo/orchestra/config"
+	"github.com/tifo/orchestra/services"
+)
+
+// exportLaunchd writes a launchd agent for every service instance. launchd
+// has no dependencies between jobs, agents only carry the environment,
+// working directory, command line, restart policy and log file of the
+// services.
+func exportLaunchd(svcs map[string]*services.Service, out string) {
+	if err := os.MkdirAll(out, 0755); err != nil {
+		appendError(err)
+		terminal.Stdout.Colorf("@{r}error: @{|}%v\n", err)
+		return
+	}
+	for _, service := range services.Sort(svcs) {
+		for _, instance := range service.Instances(service.Scale) {
+			label := strings.Replace(unitPrefix(), "-", ".", 1) + "." + instanceUnitName(instance)
+			writeUnit(filepath.Join(out, label+".plist"), instance.Name, launchdAgent(service, instance, label))
+		}
+	}
+}
+
+func launchdAgent(service, instance *services.Service, label string) string {
+	env := getStartEnvForService(instance)
+	program, args, dir := instance.CommandLine(env)
+
+	plist := &strings.Builder{}
+	plist.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	plist.WriteString(`<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">` + "\n")
+	fmt.Fprintf(plist, "<!-- Generated by orchestra export from %s -->\n", xmlEscape(config.ConfigPath))
+	plist.WriteString("<plist version=\"1.0\">\n<dict>\n")
+	launchdKey(plist, "Label", plistString(label))
+	arguments := "<array>\n"
+	for _, arg := range append([]string{program}, args...) {
+		arguments += "\t\t" + plistString(arg) + "\n"
+	}
+	launchdKey(plist, "ProgramArguments", arguments+"\t</array>")
+	launchdKey(plist, "WorkingDirectory", plistString(filepath.Clean(dir)))
+	vars := serviceEnvVars(instance)
+	variables := "<dict>\n"
+	for _, name := range sortedKeys(vars) {
+		variables += "\t\t<key>" + xmlEscape(name) + "</key>\n\t\t" + plistString(vars[name]) + "\n"
+	}
+	launchdKey(plist, "EnvironmentVariables", variables+"\t</dict>")
+	launchdKey(plist, "RunAtLoad", "<true/>")
+	switch service.Restart.Policy {
+	case services.RestartNever:
+		launchdKey(plist, "KeepAlive", "<false/>")
+	case services.RestartAlways:
+		launchdKey(plist, "KeepAlive", "<true/>")
+	default:
+		launchdKey(plist, "KeepAlive", "<dict>\n\t\t<key>SuccessfulExit</key>\n\t\t<false/>\n\t</dict>")
+	}
+	launchdKey(plist, "ThrottleInterval", fmt.Sprintf("<integer>%d</integer>", int(service.Restart.Delay(0).Seconds()+0.5)))
+	if service.StopTimeout > 0 {
+		launchdKey(plist, "ExitTimeOut", fmt.Sprintf("<integer>%d</integer>", int(service.StopTimeout.Seconds()+0.5)))
+	}
+	launchdKey(plist, "StandardOutPath", plistString(instance.LogFilePath))
+	launchdKey(plist, "StandardErrorPath", plistString(instance.LogFilePath))
+	plist.WriteString("</dict>\n</plist>\n")
+	return plist.String()
+}
+
+func launchdKey(plist *strings.Builder, key, value string) {
+	fmt.Fprintf(plist, "\t<key>%s</key>\n\t%s\n", key, value)
+}
+
+func plistString(value string) string {
+	return "<string>" + xmlEscape(value) + "</string>"
+}
+
+func xmlEscape(value string) string {
+	escaped := &strings.Builder{}
+	_ = xml.EscapeText(escaped, []byte(value))
+	return escaped.String()
+}
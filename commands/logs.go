@@ -29,7 +29,7 @@ func init() {
 func LogsAction(c *cli.Context) error {
 	go ConsumeLogs()
 	wg := &sync.WaitGroup{}
-	for _, service := range FilterServices(c) {
+	for _, service := range instancesOf(services.Sort(FilterServices(c))) {
 		wg.Add(1)
 		go TailServiceLog(service, wg)
 	}
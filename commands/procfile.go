@@ -0,0 +1,272 @@
+package commands
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"github.com/wsxiaoys/terminal"
+	"gopkg.in/yaml.v3"
+
+	"github.com/tifo/orchestra/config"
+	"github.com/tifo/orchestra/services"
+)
+
+var ImportCommand = &cli.Command{
+	Name:  "import",
+	Usage: "Imports services from other tools",
+	Subcommands: []*cli.Command{
+		{
+			Name:      "procfile",
+			Usage:     "Creates an exec service for every process of a Procfile",
+			ArgsUsage: "[Procfile]",
+			Action:    BeforeAfterWrapper(ImportProcfileAction),
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "force",
+					Usage: "Overwrite the service.yml of existing services",
+				},
+				&cli.StringFlag{
+					Name:  "stack",
+					Usage: "Stack to create the services in, the project root by default",
+				},
+			},
+		},
+	},
+}
+
+var (
+	procfileLine = regexp.MustCompile(`^([A-Za-z0-9_-]+):\s*(.+)$`)
+	// Shell expansions that orchestra can't expand like the shell does, as
+	// the arguments of a service are expanded before running it
+	shellOnlyExpansion = regexp.MustCompile(`\$(\{[^}]*[^A-Za-z0-9_}][^}]*\}|[*#$@!?0-9-])`)
+)
+
+// procfileProcess is a process type of a Procfile
+type procfileProcess struct {
+	Name    string
+	Command string
+}
+
+// procfileService is the service.yml written for a process type
+type procfileService struct {
+	Type    string         `yaml:"type"`
+	Command string         `yaml:"command"`
+	Args    []string       `yaml:"args"`
+	WorkDir string         `yaml:"workdir,omitempty"`
+	Ports   map[string]int `yaml:"ports,omitempty"`
+}
+
+// parseProcfile reads the `name: command` lines of a Procfile, blank lines
+// and comments are skipped
+func parseProcfile(r io.Reader) ([]procfileProcess, error) {
+	processes := make([]procfileProcess, 0)
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		match := procfileLine.FindStringSubmatch(line)
+		if match == nil {
+			return nil, fmt.Errorf("line %d: expected `<name>: <command>`", n)
+		}
+		if seen[match[1]] {
+			return nil, fmt.Errorf("line %d: duplicate process %s", n, match[1])
+		}
+		seen[match[1]] = true
+		processes = append(processes, procfileProcess{Name: match[1], Command: match[2]})
+	}
+	return processes, scanner.Err()
+}
+
+// ImportProcfileAction creates a directory with a service.yml for every
+// process of the Procfile (the one of the project by default), in a stack of
+// the project. The services run the process command with `sh -c` from the
+// directory of the Procfile, and get a PORT like with foreman when they use
+// one.
+func ImportProcfileAction(c *cli.Context) error {
+	// Services outside of the stacks wouldn't be discovered
+	stack := filepath.Clean(c.String("stack"))
+	if !isStack(stack) {
+		err := fmt.Errorf("Stack %s isn't listed in the stacks of %s, the services wouldn't be found", stack, filepath.Base(config.ConfigPath))
+		if !c.IsSet("stack") {
+			err = fmt.Errorf("The project root isn't listed in the stacks of %s, import the services into one with --stack", filepath.Base(config.ConfigPath))
+		}
+		appendError(err)
+		terminal.Stdout.Colorf("@{r}error: @{|}%v\n", err)
+		return nil
+	}
+
+	procfilePath := filepath.Join(services.ProjectPath, "Procfile")
+	if c.Args().Present() {
+		procfilePath, _ = filepath.Abs(c.Args().First())
+	}
+	file, err := os.Open(procfilePath)
+	if err != nil {
+		appendError(err)
+		terminal.Stdout.Colorf("@{r}error: @{|}%v\n", err)
+		return nil
+	}
+	defer file.Close()
+	processes, err := parseProcfile(file)
+	if err != nil {
+		err = fmt.Errorf("Error parsing %s: %v", procfilePath, err)
+		appendError(err)
+		terminal.Stdout.Colorf("@{r}error: @{|}%v\n", err)
+		return nil
+	}
+
+	maxLength := 0
+	for _, process := range processes {
+		if len(process.Name) > maxLength {
+			maxLength = len(process.Name)
+		}
+	}
+	for i, process := range processes {
+		spacing := strings.Repeat(" ", maxLength+2-len(process.Name))
+		serviceDir := filepath.Join(services.ProjectPath, stack, process.Name)
+		serviceConfigPath := filepath.Join(serviceDir, "service.yml")
+		if _, err := os.Stat(serviceConfigPath); err == nil && !c.Bool("force") {
+			terminal.Stdout.Colorf("%s%s| @{y} skipped@{|} (%s already exists, use --force to overwrite it)\n", process.Name, spacing, serviceConfigPath)
+			continue
+		}
+
+		service := procfileService{
+			Type:    services.TypeExec,
+			Command: "sh",
+			Args:    []string{"-c", process.Command},
+		}
+		if dir := filepath.Dir(procfilePath); filepath.Clean(dir) == filepath.Clean(services.ProjectPath) {
+			service.WorkDir = "$ORCHESTRA_PROJECT_DIR"
+		} else {
+			service.WorkDir, _ = filepath.Rel(serviceDir, dir)
+		}
+		if strings.Contains(process.Command, "$PORT") || strings.Contains(process.Command, "${PORT}") {
+			service.Ports = map[string]int{"PORT": 5000 + 100*i}
+		}
+		err := writeProcfileService(serviceDir, serviceConfigPath, service)
+		if err != nil {
+			appendError(err)
+			terminal.Stdout.Colorf("%s%s| @{r} error: @{|}%v\n", process.Name, spacing, err)
+			continue
+		}
+		terminal.Stdout.Colorf("%s%s| @{g} imported@{|} (%s)\n", process.Name, spacing, serviceConfigPath)
+		if shellOnlyExpansion.MatchString(process.Command) {
+			terminal.Stdout.Colorf("%s%s| @{y} warning: @{|}orchestra expands the variables of the command before the shell, check the ones using shell syntax\n", process.Name, spacing)
+		}
+	}
+	return nil
+}
+
+// isStack tells if a directory, relative to the project, is one of the
+// stacks where services are discovered
+func isStack(dir string) bool {
+	for _, stack := range config.GetStacks() {
+		if filepath.Clean(stack) == dir {
+			return true
+		}
+	}
+	return false
+}
+
+func writeProcfileService(dir, path string, service procfileService) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	b, err := yaml.Marshal(service)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append([]byte("# Imported from a Procfile\n"), b...), 0644)
+}
+
+// exportProcfile writes a Procfile line for every service, to be run from
+// the project directory. Service names are used as process names, with the
+// stack separator replaced.
+func exportProcfile(w io.Writer, svcs map[string]*services.Service) {
+	for _, service := range services.Sort(svcs) {
+		name := strings.Replace(service.Name, "/", "_", -1)
+		fmt.Fprintf(w, "%s: %s\n", name, procfileCommand(service))
+	}
+}
+
+// procfileCommand returns the shell command line of a service, like
+// CommandLine but keeping its variables for the shell to expand. The
+// `sh -c` commands of imported services are written back as they were.
+func procfileCommand(service *services.Service) string {
+	expand := func(value string) string {
+		return os.Expand(value, func(name string) string {
+			switch name {
+			case "ORCHESTRA_PROJECT_DIR":
+				return filepath.Clean(services.ProjectPath)
+			case "ORCHESTRA_SERVICE_DIR":
+				return service.Path
+			}
+			return "${" + name + "}"
+		})
+	}
+
+	dir := services.ProjectPath
+	if service.GoRun || service.Type == services.TypeExec {
+		dir = service.Path
+	}
+	if service.WorkDir != "" {
+		dir = expand(service.WorkDir)
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(service.Path, dir)
+		}
+	}
+
+	var command string
+	shellLine := service.Command == "sh" && len(service.Args) == 2 && service.Args[0] == "-c"
+	switch {
+	case shellLine:
+		command = service.Args[1]
+	case service.Command != "":
+		command = shellQuote(expand(service.Command)) + shellArgs(service.Args, expand)
+	case service.GoRun:
+		pkg := "."
+		if filepath.Clean(dir) != filepath.Clean(service.Path) {
+			pkg = service.Path
+		}
+		command = "go run " + shellQuote(pkg) + shellArgs(service.Args, expand)
+	default:
+		command = shellQuote(service.BinPath) + shellArgs(service.Args, expand)
+	}
+
+	if rel, err := filepath.Rel(services.ProjectPath, dir); err != nil || rel != "." {
+		if err != nil || strings.HasPrefix(rel, "..") {
+			rel = dir
+		}
+		if shellLine {
+			// The line may have several commands, it can't simply follow cd
+			command = "sh -c '" + strings.Replace(command, "'", `'\''`, -1) + "'"
+		}
+		return "cd " + shellQuote(rel) + " && " + command
+	}
+	return command
+}
+
+func shellArgs(args []string, expand func(string) string) string {
+	quoted := ""
+	for _, arg := range args {
+		quoted += " " + shellQuote(expand(arg))
+	}
+	return quoted
+}
+
+// shellQuote quotes a word for the shell when needed. Double quotes are used
+// so that the variables it references are still expanded.
+func shellQuote(word string) string {
+	if word != "" && strings.Trim(word, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-+=/.,:@%${}") == "" {
+		return word
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "`", "\\`").Replace(word) + `"`
+}
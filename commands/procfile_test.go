@@ -0,0 +1,204 @@
+package commands
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/tifo/orchestra/services"
+)
+
+func TestParseProcfile(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    []procfileProcess
+		wantErr string
+	}{
+		{
+			name: "comments and blank lines",
+			data: "# processes\n\nweb: bundle exec rails s -p $PORT\n   \n  # indented comment\nworker:sidekiq\n",
+			want: []procfileProcess{
+				{Name: "web", Command: "bundle exec rails s -p $PORT"},
+				{Name: "worker", Command: "sidekiq"},
+			},
+		},
+		{
+			name:    "duplicate process names",
+			data:    "web: one\nworker: two\nweb: three\n",
+			wantErr: "line 3: duplicate process web",
+		},
+		{
+			name:    "line without a command",
+			data:    "web: one\nworker\n",
+			wantErr: "line 2: expected `<name>: <command>`",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseProcfile(strings.NewReader(tt.data))
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("parseProcfile() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseProcfile() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImportProcfile(t *testing.T) {
+	const existing = "type: exec\ncommand: ./web\n"
+	procfile := "web: ./server --port $PORT\nworker: ./worker\napi: ./api --listen :${PORT}\n"
+
+	tests := []struct {
+		name  string
+		force bool
+		want  map[string]*procfileService
+	}{
+		{
+			name: "existing services are kept",
+			want: map[string]*procfileService{
+				"web":    nil,
+				"worker": {Type: "exec", Command: "sh", Args: []string{"-c", "./worker"}, WorkDir: "$ORCHESTRA_PROJECT_DIR"},
+				"api":    {Type: "exec", Command: "sh", Args: []string{"-c", "./api --listen :${PORT}"}, WorkDir: "$ORCHESTRA_PROJECT_DIR", Ports: map[string]int{"PORT": 5200}},
+			},
+		},
+		{
+			name:  "existing services are overwritten with --force",
+			force: true,
+			want: map[string]*procfileService{
+				"web":    {Type: "exec", Command: "sh", Args: []string{"-c", "./server --port $PORT"}, WorkDir: "$ORCHESTRA_PROJECT_DIR", Ports: map[string]int{"PORT": 5000}},
+				"worker": {Type: "exec", Command: "sh", Args: []string{"-c", "./worker"}, WorkDir: "$ORCHESTRA_PROJECT_DIR"},
+				"api":    {Type: "exec", Command: "sh", Args: []string{"-c", "./api --listen :${PORT}"}, WorkDir: "$ORCHESTRA_PROJECT_DIR", Ports: map[string]int{"PORT": 5200}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := testProject(t, map[string]string{
+				"Procfile":        procfile,
+				"web/service.yml": existing,
+			})
+			args := []string{"procfile"}
+			if tt.force {
+				args = append(args, "--force")
+			}
+			runCommand(t, ImportCommand, args...)
+			if HasErrors() {
+				t.Fatalf("import failed: %v", errorBucket)
+			}
+
+			for name, want := range tt.want {
+				b, err := os.ReadFile(filepath.Join(dir, name, "service.yml"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want == nil {
+					if string(b) != existing {
+						t.Errorf("%s/service.yml was overwritten:\n%s", name, b)
+					}
+					continue
+				}
+				got := &procfileService{}
+				if err := yaml.Unmarshal(b, got); err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("%s/service.yml = %+v, want %+v", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestExportProcfile(t *testing.T) {
+	dir := testProject(t, map[string]string{
+		"imported/service.yml": "type: exec\ncommand: sh\nargs: [-c, 'echo \"it''s\"']\nworkdir: $ORCHESTRA_PROJECT_DIR\n",
+		"worker/service.yml":   "type: exec\ncommand: sh\nargs: [-c, 'basename \"$PWD\"; echo ''it''\"''\"''s''']\n",
+		"tool/service.yml":     "type: exec\ncommand: ./tool\nargs: [--name, \"it's $NAME\"]\n",
+	})
+
+	out := &bytes.Buffer{}
+	exportProcfile(out, services.Registry)
+	want := "imported: echo \"it's\"\n" +
+		"tool: cd tool && ./tool --name \"it's ${NAME}\"\n" +
+		"worker: cd worker && sh -c 'basename \"$PWD\"; echo '\\''it'\\''\"'\\''\"'\\''s'\\'''\n"
+	if out.String() != want {
+		t.Fatalf("exportProcfile() =\n%s\nwant\n%s", out, want)
+	}
+
+	// The shell runs every line from the project directory like it was
+	// imported, with the quotes of the command intact
+	outputs := map[string]string{
+		"imported": "it's\n",
+		"worker":   "worker\nit's\n",
+	}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		name, command, _ := strings.Cut(line, ": ")
+		want, ok := outputs[name]
+		if !ok {
+			continue
+		}
+		cmd := exec.Command("sh", "-c", command)
+		cmd.Dir = dir
+		got, err := cmd.CombinedOutput()
+		if err != nil || string(got) != want {
+			t.Errorf("%s: sh -c %q = %q, %v, want %q", name, command, got, err, want)
+		}
+	}
+}
+
+func TestImportProcfileStack(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		service string
+	}{
+		{"root outside of the stacks", nil, ""},
+		{"unknown stack", []string{"--stack", "other"}, ""},
+		{"stack", []string{"--stack", "api"}, "api/web"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := testProject(t, map[string]string{
+				"orchestra.yml": "stacks: [api]\n",
+				"Procfile":      "web: ./server\n",
+				"api/.keep":     "",
+			})
+			runCommand(t, ImportCommand, append([]string{"procfile"}, tt.args...)...)
+			if tt.service == "" {
+				if !HasErrors() {
+					t.Error("import succeeded outside of the stacks")
+				}
+				matches, _ := filepath.Glob(filepath.Join(dir, "*", "web"))
+				if _, err := os.Stat(filepath.Join(dir, "web")); err == nil || len(matches) > 0 {
+					t.Errorf("import wrote services outside of the stacks: %v", matches)
+				}
+				return
+			}
+			if HasErrors() {
+				t.Fatalf("import failed: %v", errorBucket)
+			}
+			// The imported services are discovered
+			services.Registry = make(map[string]*services.Service)
+			services.StackRegistry = make(map[string][]*services.Service)
+			services.Init()
+			if _, ok := services.Registry[tt.service]; !ok {
+				t.Errorf("service %s not discovered after the import", tt.service)
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"os/exec"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -13,6 +14,7 @@ import (
 	"github.com/wsxiaoys/terminal"
 
 	"github.com/tifo/orchestra/services"
+	"github.com/tifo/orchestra/vendors"
 )
 
 var PsCommand = &cli.Command{
@@ -24,41 +26,141 @@ var PsCommand = &cli.Command{
 
 // PsAction checks the status for every service and output
 func PsAction(c *cli.Context) error {
-	svcs := services.Sort(FilterServices(c))
+	bases := services.Sort(FilterServices(c))
+	svcs := instancesOf(bases)
+
+	hasHealthChecks := false
+	statuses := make(map[string]*serviceStatus)
+	for _, svc := range svcs {
+		statuses[svc.Name] = &serviceStatus{}
+		hasHealthChecks = hasHealthChecks || svc.HealthCheck != nil
+	}
 
 	var wg sync.WaitGroup
 	for _, svc := range svcs {
 		wg.Add(1)
-		go func(s *services.Service) {
-			s.Ports = getPorts(s)
-			wg.Done()
-		}(svc)
+		go func(s *services.Service, status *serviceStatus) {
+			defer wg.Done()
+			status.children = s.Children()
+			s.Ports = getPorts(s, status.children)
+			if s.Resources != nil && s.Process != nil {
+				status.usage = s.ResourceUsage(status.children)
+			}
+			if s.HealthCheck != nil && s.Process != nil {
+				status.healthy = s.HealthCheck.Probe(s, GetEnvForService(c, s)) == nil
+			}
+		}(svc, statuses[svc.Name])
+	}
+	strays := make([][]services.ProcessInfo, len(bases))
+	for i, base := range bases {
+		wg.Add(1)
+		go func(i int, s *services.Service) {
+			defer wg.Done()
+			strays[i] = s.Strays(managedPids(s))
+		}(i, base)
 	}
 	wg.Wait()
 
 	for _, service := range svcs {
 		spacing := strings.Repeat(" ", services.MaxServiceNameLength+2-len(service.Name))
+		status := statuses[service.Name]
 		if service.Process != nil {
-			terminal.Stdout.Colorf("@{g}%s", service.Name).Reset().Colorf("%s|", spacing).Print(" running ").Colorf("  %d  %s\n", service.Process.Pid, service.Ports)
+			health := ""
+			if hasHealthChecks {
+				health = healthColumn(service, status)
+			}
+			terminal.Stdout.Colorf("@{g}%s", service.Name).Reset().Colorf("%s|", spacing).Print(" running ").Colorf(health+"  %d  %s%s\n", service.Process.Pid, service.Ports, supervision(service))
+			childSpacing := strings.Repeat(" ", services.MaxServiceNameLength+2)
+			if service.Resources != nil {
+				terminal.Stdout.Colorf("%s|   @{c}%s\n", childSpacing, resourcesColumn(service.Resources, status.usage))
+			}
+			for _, child := range status.children {
+				terminal.Stdout.Colorf("%s|   @{w}└ %d  %s\n", childSpacing, child.Pid, child.Command)
+			}
+		} else if service.Supervisor != nil {
+			terminal.Stdout.Colorf("@{y}%s", service.Name).Reset().Colorf("%s|", spacing).Print(" restarting").Colorf("%s\n", supervision(service))
 		} else {
 			terminal.Stdout.Colorf("@{r}%s", service.Name).Reset().Colorf("%s|", spacing).Reset().Print(" aborted\n")
 		}
 	}
+	for i, base := range bases {
+		spacing := strings.Repeat(" ", services.MaxServiceNameLength+2-len(base.Name))
+		for _, stray := range strays[i] {
+			terminal.Stdout.Colorf("@{y}%s", base.Name).Reset().Colorf("%s|", spacing).Print(" unmanaged").Colorf("  %d  %s\n", stray.Pid, stray.Command)
+		}
+	}
+	// Vendors are listed unless specific services were asked for
+	if len(vendors.Registry) > 0 && c.Args().Len() == 0 {
+		if runtime, err := vendorsRuntime(); err == nil {
+			printVendors(runtime, vendors.Sort(vendors.Registry))
+		}
+	}
 	return nil
 }
 
-func getPorts(service *services.Service) string {
+// serviceStatus collects what ps shows about a service besides its process
+type serviceStatus struct {
+	children []services.ProcessInfo
+	healthy  bool
+	usage    services.ResourceUsage
+}
+
+func healthColumn(service *services.Service, status *serviceStatus) string {
+	switch {
+	case service.HealthCheck == nil:
+		return strings.Repeat(" ", len("unhealthy")+1)
+	case status.healthy:
+		return " @{g}healthy@{|}  "
+	default:
+		return " @{r}unhealthy@{|}"
+	}
+}
+
+// resourcesColumn shows the usage of a service against its limits, e.g.
+// "mem 120M/512M  cpu 12%/50%  files 24/1024"
+func resourcesColumn(limits *services.Resources, usage services.ResourceUsage) string {
+	column := fmt.Sprintf("mem %s", usage.Memory)
+	if limits.Memory > 0 {
+		column += fmt.Sprintf("/%s", limits.Memory)
+	}
+	column += fmt.Sprintf("  cpu %.0f%%", usage.CPU*100)
+	if limits.CPU > 0 {
+		column += fmt.Sprintf("/%.0f%%", limits.CPU*100)
+	}
+	column += fmt.Sprintf("  files %d", usage.Files)
+	if limits.NoFile > 0 {
+		column += fmt.Sprintf("/%d", limits.NoFile)
+	}
+	if limits.Nice != 0 {
+		column += fmt.Sprintf("  nice %d", limits.Nice)
+	}
+	return column
+}
+
+func supervision(service *services.Service) string {
+	if service.Supervisor == nil {
+		return ""
+	}
+	return fmt.Sprintf(" (supervised, %d restarts)", service.Restarts)
+}
+
+// getPorts lists the ports the service, or any of its children, listens on
+func getPorts(service *services.Service, children []services.ProcessInfo) string {
 	if service.Process == nil {
 		return ""
 	}
 
+	pids := []string{strconv.Itoa(service.Process.Pid)}
+	for _, child := range children {
+		pids = append(pids, strconv.Itoa(child.Pid))
+	}
 	re := regexp.MustCompile("LISTEN")
-	cmd := exec.Command("lsof", "-P", "-p", fmt.Sprintf("%d", service.Process.Pid))
+	cmd := exec.Command("lsof", "-P", "-p", strings.Join(pids, ","))
 	output := bytes.NewBuffer([]byte{})
 	cmd.Stdout = output
-	cmd.Stderr = output
 	err := cmd.Run()
-	if err != nil {
+	// lsof fails when one of the pids is gone, e.g. a short-lived child
+	if err != nil && output.Len() == 0 {
 		return fmt.Sprintf("error: %v", err)
 	}
 	lsofOutput := ""
@@ -1,7 +1,7 @@
 package commands
 
 import (
-	"runtime"
+	"io"
 	"strings"
 
 	"github.com/urfave/cli/v2"
@@ -24,23 +24,27 @@ var RestartCommand = &cli.Command{
 			Name:  "logs, l",
 			Usage: "Start logging after start",
 		},
+		&cli.BoolFlag{
+			Name:  "supervise",
+			Usage: "Keep a supervisor alive that restarts the services according to their restart policy",
+		},
 	},
 }
 
-// RestartAction restarts all the services (or the specified ones)
+// RestartAction restarts all the services (or the specified ones), in
+// dependency order
 func RestartAction(c *cli.Context) error {
-	worker := func(service *services.Service) func() {
-		return func() {
-			restart(c, service)
-		}
+	svcs := FilterServices(c)
+	waves, err := services.Waves(svcs)
+	if err != nil {
+		appendError(err)
+		terminal.Stdout.Colorf("@{r}error: @{|}%v\n", err)
+		return nil
 	}
 
-	pool := make(workerPool, runtime.NumCPU())
-	svcs := services.Sort(FilterServices(c))
-	for _, service := range svcs {
-		pool.Do(worker(service))
-	}
-	pool.Drain()
+	runInWaves(waves, func(service *services.Service) bool {
+		return restart(c, service, nil)
+	})
 
 	if c.Bool("attach") || c.Bool("logs") {
 		_ = LogsAction(c)
@@ -48,27 +52,69 @@ func RestartAction(c *cli.Context) error {
 	return nil
 }
 
-func restart(c *cli.Context, service *services.Service) {
+// restart builds a service and restarts its instances, the build output is
+// streamed to output when not nil
+func restart(c *cli.Context, service *services.Service, output io.Writer) bool {
 	spacing := strings.Repeat(" ", services.MaxServiceNameLength+2-len(service.Name))
-
-	err := killService(service)
+	unlock, err := lockService(c, service)
 	if err != nil {
 		appendError(err)
-		terminal.Stdout.Colorf("%s%s| @{r} error: @{|}%s\n", service.Name, spacing, err.Error())
-		return
+		terminal.Stdout.Colorf("%s%s| @{r} error: @{|}%v\n", service.Name, spacing, err)
+		return false
 	}
+	defer unlock()
 
-	rebuilt, err := buildAndStart(c, service)
+	// Build first, so that a failing build leaves the running instances alone
+	rebuilt, err := prepareService(c, service, output)
 	if err != nil {
 		appendError(err)
 		terminal.Stdout.Colorf("%s%s| @{r} error: @{|}%s\n", service.Name, spacing, err.Error())
-		return
+		return false
+	}
+
+	instances := service.Instances(service.InstanceCount())
+
+	// Supervised instances stay supervised across restarts
+	supervise := make(map[string]bool)
+	how := make(map[string]string)
+	for _, instance := range instances {
+		supervise[instance.Name] = c.Bool("supervise") || instance.Supervisor != nil
+	}
+	running := anyRunning(instances)
+	if running {
+		reportHook(c, service, services.HookPreStop)
+	}
+	for _, instance := range instances {
+		instanceHow, err := killService(instance)
+		if err != nil {
+			appendError(err)
+			instanceSpacing := strings.Repeat(" ", services.MaxServiceNameLength+2-len(instance.Name))
+			terminal.Stdout.Colorf("%s%s| @{r} error: @{|}%s\n", instance.Name, instanceSpacing, err.Error())
+			return false
+		}
+		how[instance.Name] = instanceHow
+	}
+	if running {
+		reportHook(c, service, services.HookPostStop)
+	}
+
+	if !reportHook(c, service, services.HookPreStart) {
+		return false
 	}
 
 	var rebuiltStatus string
 	if rebuilt {
 		rebuiltStatus = "rebuilt & "
 	}
-
-	terminal.Stdout.Colorf("%s%s| @{c} %srestarted\n", service.Name, spacing, rebuiltStatus)
+	restarted := forEachInstance(instances, func(instance *services.Service) bool {
+		instanceSpacing := strings.Repeat(" ", services.MaxServiceNameLength+2-len(instance.Name))
+		if err := startInstance(c, instance, supervise[instance.Name], nil); err != nil {
+			appendError(err)
+			terminal.Stdout.Colorf("%s%s| @{r} error: @{|}%s\n", instance.Name, instanceSpacing, err.Error())
+			return false
+		}
+		terminal.Stdout.Colorf("%s%s| @{c} %srestarted@{|} %s\n", instance.Name, instanceSpacing, rebuiltStatus, how[instance.Name])
+		return true
+	})
+	return restarted && reportHook(c, service, services.HookPostStart)
 }
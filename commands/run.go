@@ -0,0 +1,103 @@
+package commands
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+
+	"github.com/urfave/cli/v2"
+	"github.com/wsxiaoys/terminal"
+
+	"github.com/tifo/orchestra/services"
+)
+
+var RunCommand = &cli.Command{
+	Name:         "run",
+	Usage:        "Runs a command (or a shell) in the environment of a service",
+	ArgsUsage:    "<service> [-- <command> [args...]]",
+	Action:       BeforeAfterWrapper(RunAction),
+	BashComplete: ServicesBashComplete,
+}
+
+// RunAction runs a one-off command from the directory of a service, with the
+// environment the service gets, and exits with the exit code of the command.
+// Without a command, it runs an interactive $SHELL.
+func RunAction(c *cli.Context) error {
+	if c.Args().Len() == 0 {
+		err := errors.New("Missing service, e.g. `orchestra run <service> -- <command>`")
+		appendError(err)
+		terminal.Stdout.Colorf("@{r}error: @{|}%v\n", err)
+		return nil
+	}
+	service := singleService(c.Args().First(), terminal.Stdout)
+	if service == nil {
+		return nil
+	}
+
+	args := c.Args().Tail()
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		shell := os.Getenv("SHELL")
+		if shell == "" {
+			shell = "/bin/sh"
+		}
+		args = []string{shell}
+		terminal.Stderr.Colorf("@{c}%s@{|} environment in %s, exit to leave\n", service.Name, shell)
+	}
+
+	code, err := runInService(service, args)
+	if err != nil {
+		appendError(err)
+		terminal.Stdout.Colorf("@{r}error: @{|}%v\n", err)
+	}
+	setExitCode(code)
+	return nil
+}
+
+// runInService runs a command attached to the terminal, from the service
+// directory and with the environment it is started with. Interrupts from the
+// terminal reach the command, orchestra waits for it and returns its exit
+// code.
+func runInService(service *services.Service, args []string) (int, error) {
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = service.Path
+	cmd.Env = getStartEnvForService(service)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	// SIGINT and SIGQUIT are sent to the whole foreground process group by
+	// the terminal, other signals are forwarded to the command
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGHUP)
+	defer close(signals)
+	defer signal.Stop(signals)
+
+	if err := cmd.Start(); err != nil {
+		return 127, err
+	}
+	go func() {
+		for sig := range signals {
+			if sig == syscall.SIGTERM || sig == syscall.SIGHUP {
+				_ = cmd.Process.Signal(sig)
+			}
+		}
+	}()
+
+	err := cmd.Wait()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return 128 + int(status.Signal()), nil
+		}
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
@@ -0,0 +1,100 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+
+	"github.com/urfave/cli/v2"
+	"github.com/wsxiaoys/terminal"
+
+	"github.com/tifo/orchestra/services"
+)
+
+var SignalCommand = &cli.Command{
+	Name:         "signal",
+	Usage:        "Sends a signal to all the services (or the specified ones)",
+	ArgsUsage:    "<signal> [services...]",
+	Action:       BeforeAfterWrapper(SignalAction),
+	BashComplete: ServicesBashComplete,
+}
+
+var ReloadCommand = &cli.Command{
+	Name:         "reload",
+	Usage:        "Reloads all the services (or the specified ones) with their reload_signal, or restarts them",
+	Action:       BeforeAfterWrapper(ReloadAction),
+	BashComplete: ServicesBashComplete,
+}
+
+// SignalAction sends a signal (e.g. HUP, SIGUSR1 or 10) to every running
+// instance of the selected services
+func SignalAction(c *cli.Context) error {
+	if c.Args().Len() == 0 {
+		err := errors.New("Missing signal, e.g. `orchestra signal SIGHUP [services...]`")
+		appendError(err)
+		terminal.Stdout.Colorf("@{r}error: @{|}%v\n", err)
+		return nil
+	}
+	sig, err := services.ParseSignal(c.Args().First())
+	if err != nil {
+		appendError(err)
+		terminal.Stdout.Colorf("@{r}error: @{|}%v\n", err)
+		return nil
+	}
+	for _, service := range services.Sort(filterServices(c.Args().Tail(), nil)) {
+		signalService(c, service, sig, "signaled")
+	}
+	return nil
+}
+
+// ReloadAction sends their reload signal to the selected services, the
+// services without one are restarted
+func ReloadAction(c *cli.Context) error {
+	svcs := FilterServices(c)
+	waves, err := services.Waves(svcs)
+	if err != nil {
+		appendError(err)
+		terminal.Stdout.Colorf("@{r}error: @{|}%v\n", err)
+		return nil
+	}
+	runInWaves(waves, func(service *services.Service) bool {
+		if service.ReloadSignal == 0 {
+			return restart(c, service, nil)
+		}
+		return signalService(c, service, service.ReloadSignal, "reloaded")
+	})
+	return nil
+}
+
+// signalService sends a signal to the main process of every running instance
+// of the service, and reports how it went for each of them
+func signalService(c *cli.Context, service *services.Service, sig syscall.Signal, done string) bool {
+	spacing := strings.Repeat(" ", services.MaxServiceNameLength+2-len(service.Name))
+	unlock, err := lockService(c, service)
+	if err != nil {
+		appendError(err)
+		terminal.Stdout.Colorf("%s%s| @{r} error: @{|}%v\n", service.Name, spacing, err)
+		return false
+	}
+	defer unlock()
+
+	success := true
+	for _, instance := range service.Instances(service.InstanceCount()) {
+		instanceSpacing := strings.Repeat(" ", services.MaxServiceNameLength+2-len(instance.Name))
+		if instance.Process == nil {
+			terminal.Stdout.Colorf("%s%s| @{y} not running\n", instance.Name, instanceSpacing)
+			continue
+		}
+		pid := instance.MainPid()
+		if err := syscall.Kill(pid, sig); err != nil {
+			success = false
+			err = fmt.Errorf("Failed to send %s to %s (pid %d): %v", services.SignalName(sig), instance.Name, pid, err)
+			appendError(err)
+			terminal.Stdout.Colorf("%s%s| @{r} error: @{|}%v\n", instance.Name, instanceSpacing, err)
+			continue
+		}
+		terminal.Stdout.Colorf("%s%s| @{c} %s@{|} (%s to pid %d)\n", instance.Name, instanceSpacing, done, services.SignalName(sig), pid)
+	}
+	return success
+}
@@ -2,11 +2,12 @@ package commands
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
-	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -14,6 +15,7 @@ import (
 	"github.com/wsxiaoys/terminal"
 
 	"github.com/tifo/orchestra/services"
+	"github.com/tifo/orchestra/vendors"
 )
 
 var StartCommand = &cli.Command{
@@ -30,84 +32,344 @@ var StartCommand = &cli.Command{
 			Name:  "logs, l",
 			Usage: "Start logging after start",
 		},
+		&cli.BoolFlag{
+			Name:  "supervise",
+			Usage: "Keep a supervisor alive that restarts the services according to their restart policy",
+		},
+		&cli.BoolFlag{
+			Name:  "with-deps",
+			Usage: "Also start the dependencies of the services",
+		},
+		&cli.BoolFlag{
+			Name:  "no-vendors",
+			Usage: "Don't start the vendors before the services",
+		},
+		&cli.BoolFlag{
+			Name:  "kill-strays",
+			Usage: "Stop the service processes running outside of orchestra before starting",
+		},
+		&cli.StringSliceFlag{
+			Name:  "scale",
+			Usage: "Number of instances to run for a service, as <service>=<instances>",
+		},
 	},
 }
 
-// StartAction starts all the services (or the specified ones)
+// StartAction starts all the services (or the specified ones) in dependency
+// order: a service is started once its dependencies are healthy
 func StartAction(c *cli.Context) error {
-	worker := func(service *services.Service) func() {
-		return func() { start(c, service) }
+	svcs := FilterServices(c)
+	if c.Bool("with-deps") {
+		svcs = services.WithDependencies(svcs)
+	}
+	if err := applyScaleFlag(c, svcs); err != nil {
+		appendError(err)
+		terminal.Stdout.Colorf("@{r}error: @{|}%v\n", err)
+		return nil
+	}
+	waves, err := services.Waves(svcs)
+	if err != nil {
+		appendError(err)
+		terminal.Stdout.Colorf("@{r}error: @{|}%v\n", err)
+		return nil
 	}
 
-	pool := make(workerPool, runtime.NumCPU())
-	svcs := services.Sort(FilterServices(c))
-	for _, service := range svcs {
-		pool.Do(worker(service))
+	// The services may need any of the vendors
+	if len(vendors.Registry) > 0 && !c.Bool("no-vendors") {
+		runtime, err := vendorsRuntime()
+		if err != nil || !startVendors(runtime, vendors.Sort(vendors.Registry)) {
+			return nil
+		}
 	}
-	pool.Drain()
+
+	// Ctrl-C stops waiting for the services being started, and skips the
+	// next ones
+	interrupted, stopInterrupt := interruptChannel()
+	runInWavesUntil(waves, interrupted, func(service *services.Service) bool {
+		return start(c, service, svcs, interrupted)
+	})
+	stopInterrupt()
 	if c.Bool("attach") || c.Bool("logs") {
 		_ = LogsAction(c)
 	}
 	return nil
 }
 
-func start(c *cli.Context, service *services.Service) {
+func start(c *cli.Context, service *services.Service, svcs map[string]*services.Service, stop <-chan struct{}) bool {
 	spacing := strings.Repeat(" ", services.MaxServiceNameLength+2-len(service.Name))
-	if service.Process == nil {
-		rebuilt, err := buildAndStart(c, service)
-		if err != nil {
-			appendError(err)
-			terminal.Stdout.Colorf("%s%s| @{r} error: @{|}%v\n", service.Name, spacing, err)
+	unlock, err := lockService(c, service)
+	if err != nil {
+		appendError(err)
+		terminal.Stdout.Colorf("%s%s| @{r} error: @{|}%v\n", service.Name, spacing, err)
+		return false
+	}
+	defer unlock()
+
+	stopExtraInstances(service)
+	handleStrays(service, c.Bool("kill-strays"))
+
+	pending := make([]*services.Service, 0)
+	for _, instance := range service.Instances(service.Scale) {
+		if instance.Process != nil {
+			instanceSpacing := strings.Repeat(" ", services.MaxServiceNameLength+2-len(instance.Name))
+			terminal.Stdout.Colorf("%s%s| @{c} already running\n", instance.Name, instanceSpacing)
 		} else {
-			var rebuiltStatus string
-			if rebuilt {
-				rebuiltStatus = "(re)built and "
-			}
-			terminal.Stdout.Colorf("%s%s| @{g} %sstarted\n", service.Name, spacing, rebuiltStatus)
+			pending = append(pending, instance)
 		}
-	} else {
-		terminal.Stdout.Colorf("%s%s| @{c} already running\n", service.Name, spacing)
 	}
+	if len(pending) == 0 {
+		return true
+	}
+
+	err = checkDependencies(c, service, svcs, stop)
+	if err != nil {
+		appendError(err)
+		terminal.Stdout.Colorf("%s%s| @{r} error: @{|}%v\n", service.Name, spacing, err)
+		return false
+	}
+	rebuilt, err := prepareService(c, service, nil)
+	if err != nil {
+		appendError(err)
+		terminal.Stdout.Colorf("%s%s| @{r} error: @{|}%v\n", service.Name, spacing, err)
+		return false
+	}
+	if !reportHook(c, service, services.HookPreStart) {
+		return false
+	}
+	var rebuiltStatus string
+	if rebuilt {
+		rebuiltStatus = "(re)built and "
+	}
+	started := forEachInstance(pending, func(instance *services.Service) bool {
+		instanceSpacing := strings.Repeat(" ", services.MaxServiceNameLength+2-len(instance.Name))
+		if err := startInstance(c, instance, c.Bool("supervise"), stop); err != nil {
+			appendError(err)
+			terminal.Stdout.Colorf("%s%s| @{r} error: @{|}%v\n", instance.Name, instanceSpacing, err)
+			return false
+		}
+		terminal.Stdout.Colorf("%s%s| @{g} %sstarted\n", instance.Name, instanceSpacing, rebuiltStatus)
+		return true
+	})
+	return started && reportHook(c, service, services.HookPostStart)
+}
+
+// stopExtraInstances stops the instances running beyond the service scale,
+// e.g. after `start --scale svc=3` followed by `start --scale svc=1`
+func stopExtraInstances(service *services.Service) {
+	if len(service.OnlyInstances) > 0 {
+		return
+	}
+	for _, instance := range service.Instances(service.InstanceCount()) {
+		if instance.Instance > service.Scale && (instance.Process != nil || instance.Supervisor != nil) {
+			stop(instance)
+		}
+	}
+}
+
+// applyScaleFlag overrides the scale of the services given as svc=N
+func applyScaleFlag(c *cli.Context, svcs map[string]*services.Service) error {
+	for _, value := range c.StringSlice("scale") {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("Invalid scale %q, expected <service>=<instances>", value)
+		}
+		service, ok := svcs[parts[0]]
+		if !ok {
+			return fmt.Errorf("Can't scale %s, service not started", parts[0])
+		}
+		n, err := strconv.Atoi(parts[1])
+		if err != nil || n < 1 {
+			return fmt.Errorf("Invalid scale %q, expected a positive number of instances", value)
+		}
+		service.SetScale(n)
+	}
+	return nil
 }
 
-// startService takes a Service struct as input, creates a new log file in .orchestra,
-// redirects the command stdout and stderr to the log file, configures the environment
-// variables for the command and starts it. If cmd.Start() doesn't return any
-// error, it will write a service.pid file in .orchestra
-func buildAndStart(c *cli.Context, service *services.Service) (bool, error) {
-	cmd := exec.Command(service.BinPath)
+// checkDependencies makes sure that the dependencies of a service which are
+// not part of the started services are already running and healthy, until
+// stop is closed
+func checkDependencies(c *cli.Context, service *services.Service, svcs map[string]*services.Service, stop <-chan struct{}) error {
+	for _, dep := range service.Dependencies {
+		if _, ok := svcs[dep.Name]; ok {
+			continue
+		}
+		if dep.Process == nil {
+			return fmt.Errorf("Dependency %s of %s is not running (start it or use --with-deps)", dep.Name, service.Name)
+		}
+		if err := dep.WaitHealthy(stop, GetEnvForService(c, dep)); err != nil {
+			return fmt.Errorf("Dependency %s of %s is not ready: %v", dep.Name, service.Name, err)
+		}
+	}
+	return nil
+}
+
+// forEachInstance calls f for every instance in parallel, and tells if it
+// succeeded for all of them
+func forEachInstance(instances []*services.Service, f func(instance *services.Service) bool) bool {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	ok := true
+	for _, instance := range instances {
+		wg.Add(1)
+		go func(instance *services.Service) {
+			defer wg.Done()
+			if !f(instance) {
+				mu.Lock()
+				ok = false
+				mu.Unlock()
+			}
+		}(instance)
+	}
+	wg.Wait()
+	return ok
+}
 
-	rebuilt, err := installService(service)
+// startInstance starts an installed service instance, either directly or
+// through a detached supervisor process, then waits for it to be healthy
+// until stop is closed
+func startInstance(c *cli.Context, instance *services.Service, supervise bool, stop <-chan struct{}) error {
+	var err error
+	if supervise {
+		err = startSupervisor(c, instance)
+	} else {
+		_, err = startProcess(c, instance, nil)
+	}
 	if err != nil {
-		return rebuilt, err
+		return err
 	}
+	return instance.WaitHealthy(stop, GetEnvForService(c, instance))
+}
 
-	outputFile, err := os.Create(service.LogFilePath)
-	if err != nil && os.IsNotExist(err) {
-		return rebuilt, err
+// startProcess launches the service binary and checks it is still running
+// shortly after. The output of the service also goes to output, if not nil.
+func startProcess(c *cli.Context, service *services.Service, output io.Writer) (*serviceProcess, error) {
+	proc, err := launchService(service, GetEnvForService(c, service), c.Bool("attach"), os.O_TRUNC, output)
+	if err != nil {
+		return nil, err
 	}
-	defer outputFile.Close()
-	pidFile, err := os.Create(service.PidFilePath)
-	if err != nil && os.IsNotExist(err) {
-		return rebuilt, err
+	select {
+	case <-proc.done:
+		reportHook(c, service, services.HookOnCrash)
+		return nil, fmt.Errorf("Service %s exited after %s", service.Name, proc.cmd.ProcessState.UserTime().String())
+	case <-time.After(200 * time.Millisecond):
 	}
-	defer pidFile.Close()
-	cmd.Dir = services.ProjectPath
+	// The process is alive, but orchestra wouldn't find it again through its
+	// pid file: it can't be managed and is killed
+	if !service.IsRunning() {
+		if attr := proc.cmd.SysProcAttr; attr != nil && attr.Setpgid {
+			_ = syscall.Kill(-proc.cmd.Process.Pid, syscall.SIGKILL)
+		} else {
+			_ = proc.cmd.Process.Kill()
+		}
+		<-proc.done
+		return nil, fmt.Errorf("Service %s was started but its pid file can't be verified, killed it", service.Name)
+	}
+	return proc, nil
+}
+
+// serviceProcess is a service binary started by this orchestra process.
+// done is closed when the process exits, err then holds the result of Wait.
+type serviceProcess struct {
+	cmd  *exec.Cmd
+	done chan struct{}
+	err  error
+}
+
+// launchService takes a Service struct as input, opens its log file in .orchestra
+// (truncated or appended according to logFlag), redirects the command stdout
+// and stderr to the log file, configures the environment variables for the
+// command and starts it. If cmd.Start() doesn't return any error, it will
+// write the process state to a service.pid file in .orchestra
+//
+// When output isn't nil, the service writes to a pipe instead, copied both
+// to the log file and to output.
+func launchService(service *services.Service, env []string, attach bool, logFlag int, output io.Writer) (*serviceProcess, error) {
+	program, args, dir := service.CommandLine(env)
+	cmd := exec.Command(program, args...)
+
+	outputFile, err := os.OpenFile(service.LogFilePath, os.O_CREATE|os.O_WRONLY|logFlag, 0666)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Dir = dir
 	cmd.Stdout = outputFile
 	cmd.Stderr = outputFile
-	cmd.Env = GetEnvForService(c, service)
+	cmd.Env = env
 
-	if !c.Bool("attach") {
+	// The service gets the write end of the pipe rather than a writer, so that
+	// Wait returns when the service exits even if its children keep the pipe
+	// open
+	var pipe *os.File
+	if output != nil {
+		r, w, err := os.Pipe()
+		if err != nil {
+			outputFile.Close()
+			return nil, err
+		}
+		defer w.Close()
+		pipe = r
+		cmd.Stdout = w
+		cmd.Stderr = w
+	}
+
+	if !attach {
 		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 	}
 
+	limited, err := service.LimitCommand(cmd)
+	if err != nil {
+		outputFile.Close()
+		if pipe != nil {
+			pipe.Close()
+		}
+		return nil, err
+	}
 	if err := cmd.Start(); err != nil {
-		return rebuilt, err
+		_ = limited()
+		outputFile.Close()
+		if pipe != nil {
+			pipe.Close()
+		}
+		return nil, err
 	}
-	_, _ = pidFile.WriteString(strconv.Itoa(cmd.Process.Pid))
-	time.Sleep(200 * time.Millisecond)
-	if !service.IsRunning() {
-		return rebuilt, fmt.Errorf("Service %s exited after %s", service.Name, cmd.ProcessState.UserTime().String())
+	if pipe != nil {
+		go copyOutput(pipe, outputFile, output)
+	} else {
+		outputFile.Close()
+	}
+	if err := limited(); err != nil {
+		_ = cmd.Wait()
+		return nil, fmt.Errorf("Can't apply the resource limits of %s: %v", service.Name, err)
+	}
+	// Only the service binary is recorded: other commands may be scripts
+	// that exec something else
+	binPath := ""
+	if program == service.BinPath {
+		binPath = program
+	}
+	if err := services.WriteProcessState(service.PidFilePath, cmd.Process.Pid, binPath); err != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return nil, fmt.Errorf("Can't record the process of %s: %v", service.Name, err)
+	}
+	service.Process = cmd.Process
+
+	proc := &serviceProcess{cmd: cmd, done: make(chan struct{})}
+	go func() {
+		proc.err = cmd.Wait()
+		close(proc.done)
+	}()
+	return proc, nil
+}
+
+// copyOutput copies the output of a service to its log file and to output,
+// until every process holding the pipe has exited
+func copyOutput(pipe, logFile *os.File, output io.Writer) {
+	defer pipe.Close()
+	defer logFile.Close()
+	_, _ = io.Copy(io.MultiWriter(logFile, output), pipe)
+	if p, ok := output.(*prefixWriter); ok {
+		p.Flush()
 	}
-	return rebuilt, nil
 }
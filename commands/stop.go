@@ -1,8 +1,13 @@
 package commands
 
 import (
+	"errors"
+	"fmt"
 	"os"
+	"runtime"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/urfave/cli/v2"
 	"github.com/wsxiaoys/terminal"
@@ -17,29 +22,156 @@ var StopCommand = &cli.Command{
 	BashComplete: ServicesBashComplete,
 }
 
-// StopAction stops all the services (or the specified ones)
+// StopAction stops all the services (or the specified ones), in reverse
+// dependency order
 func StopAction(c *cli.Context) error {
-	svcs := services.Sort(FilterServices(c))
-	for _, service := range svcs {
-		spacing := strings.Repeat(" ", services.MaxServiceNameLength+2-len(service.Name))
-		err := killService(service)
-		if err != nil {
-			appendError(err)
-			terminal.Stdout.Colorf("%s%s| @{r} error: @{|}%s\n", service.Name, spacing, err.Error())
-		} else if service.Process != nil {
-			terminal.Stdout.Colorf("%s%s| @{r} stopped\n", service.Name, spacing)
+	worker := func(service *services.Service) func() {
+		return func() {
+			unlock, err := lockService(c, service)
+			if err != nil {
+				appendError(err)
+				spacing := strings.Repeat(" ", services.MaxServiceNameLength+2-len(service.Name))
+				terminal.Stdout.Colorf("%s%s| @{r} error: @{|}%v\n", service.Name, spacing, err)
+				return
+			}
+			defer unlock()
+			instances := service.Instances(service.InstanceCount())
+			running := anyRunning(instances)
+			if running {
+				reportHook(c, service, services.HookPreStop)
+			}
+			forEachInstance(instances, func(instance *services.Service) bool {
+				stop(instance)
+				return true
+			})
+			if running {
+				reportHook(c, service, services.HookPostStop)
+			}
 		}
 	}
+
+	for _, wave := range reverseWaves(FilterServices(c)) {
+		pool := make(workerPool, runtime.NumCPU())
+		for _, service := range wave {
+			pool.Do(worker(service))
+		}
+		pool.Drain()
+	}
 	return nil
 }
 
-func killService(service *services.Service) error {
-	if service.Process != nil {
-		err := service.Process.Kill()
-		defer os.Remove(service.PidFilePath)
-		if err != nil {
-			return err
+func stop(service *services.Service) {
+	spacing := strings.Repeat(" ", services.MaxServiceNameLength+2-len(service.Name))
+	wasRunning := service.Process != nil || service.Supervisor != nil
+	how, err := killService(service)
+	if err != nil {
+		appendError(err)
+		terminal.Stdout.Colorf("%s%s| @{r} error: @{|}%s\n", service.Name, spacing, err.Error())
+	} else if wasRunning {
+		terminal.Stdout.Colorf("%s%s| @{r} stopped@{|} %s\n", service.Name, spacing, how)
+	}
+}
+
+// killService sends the service its stop signal (SIGTERM unless configured
+// otherwise in service.yml) and waits up to its stop timeout for it to exit.
+// If it is still running after that, it gets killed with SIGKILL. The returned
+// string tells which of the two happened.
+//
+// Signals target the whole process tree of the service: its process group
+// when it leads one, plus any descendant that moved to a group of its own.
+func killService(service *services.Service) (string, error) {
+	// The supervisor goes first, otherwise it would bring the service back up
+	if service.Supervisor != nil {
+		_ = service.Supervisor.Kill()
+		os.Remove(service.SupervisorPidFilePath)
+		service.Supervisor = nil
+	}
+	if service.Process == nil {
+		return "", nil
+	}
+	defer service.ReleaseResources()
+	defer os.Remove(service.PidFilePath)
+	return stopProcess(service.Process.Pid, service.StopSignal, service.StopTimeout)
+}
+
+// stopProcess sends sig to the process tree of pid, and SIGKILL if it is
+// still running after timeout
+func stopProcess(pid int, sig syscall.Signal, timeout time.Duration) (string, error) {
+	tree := newProcessTree(pid)
+	err := tree.signal(sig)
+	if errors.Is(err, syscall.ESRCH) {
+		return "(already exited)", nil
+	} else if err != nil {
+		return "", err
+	}
+	if tree.waitForExit(timeout) {
+		return fmt.Sprintf("(%s)", services.SignalName(sig)), nil
+	}
+
+	err = tree.signal(syscall.SIGKILL)
+	if err != nil && !errors.Is(err, syscall.ESRCH) {
+		return "", err
+	}
+	tree.waitForExit(time.Second)
+	return fmt.Sprintf("(SIGKILL after %s timeout)", timeout), nil
+}
+
+// processTree is a snapshot of a service process and its descendants, taken
+// before signalling it: once the leader exits, its children are reparented
+// and couldn't be found anymore.
+type processTree struct {
+	pid       int
+	ownsGroup bool
+	strayPids []int
+	allPids   []int
+}
+
+func newProcessTree(pid int) *processTree {
+	tree := &processTree{pid: pid, allPids: []int{pid}}
+	pgid, err := syscall.Getpgid(pid)
+	tree.ownsGroup = err == nil && pgid == pid
+	children, _ := services.Descendants(pid)
+	for _, child := range children {
+		tree.allPids = append(tree.allPids, child.Pid)
+		if !tree.ownsGroup || child.Pgid != pid {
+			tree.strayPids = append(tree.strayPids, child.Pid)
 		}
 	}
-	return nil
+	return tree
+}
+
+// signal sends sig to the process group (or the leader only, when it shares
+// the group with orchestra) and to the descendants outside of it
+func (t *processTree) signal(sig syscall.Signal) error {
+	var err error
+	if t.ownsGroup {
+		err = syscall.Kill(-t.pid, sig)
+	} else {
+		err = syscall.Kill(t.pid, sig)
+	}
+	for _, pid := range t.strayPids {
+		_ = syscall.Kill(pid, sig)
+	}
+	return err
+}
+
+// waitForExit polls the processes until they all exit or the timeout expires
+func (t *processTree) waitForExit(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		alive := false
+		for _, pid := range t.allPids {
+			if syscall.Kill(pid, syscall.Signal(0)) == nil {
+				alive = true
+				break
+			}
+		}
+		if !alive {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
 }
@@ -0,0 +1,189 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/tifo/orchestra/config"
+	"github.com/tifo/orchestra/services"
+)
+
+// SuperviseCommand is spawned in the background by `start --supervise`, one
+// process per service. It is not meant to be run by hand.
+var SuperviseCommand = &cli.Command{
+	Name:   "supervise",
+	Usage:  "Runs a service and restarts it according to its restart policy",
+	Hidden: true,
+	Action: SuperviseAction,
+}
+
+// SuperviseAction supervises a single service instance until it exits for
+// good or the supervisor receives SIGINT/SIGTERM
+func SuperviseAction(c *cli.Context) error {
+	svcs := FilterServices(c)
+	if c.NArg() != 1 || len(svcs) != 1 {
+		return errors.New("supervise expects exactly one service")
+	}
+	for _, service := range svcs {
+		return superviseService(service.Instances(1)[0])
+	}
+	return nil
+}
+
+// startSupervisor spawns a detached `orchestra supervise` process for the
+// service and waits for it to start the service binary. The supervisor gets
+// the service environment, that is passed as is to the service.
+func startSupervisor(c *cli.Context, service *services.Service) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	logFile, err := os.OpenFile(service.LogFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(executable, SuperviseCommand.Name, service.Name)
+	cmd.Dir = services.ProjectPath
+	cmd.Env = append(GetEnvForService(c, service), "ORCHESTRA_CONFIG="+config.ConfigPath)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	exited := make(chan struct{})
+	go func() {
+		_ = cmd.Wait()
+		close(exited)
+	}()
+
+	deadline := time.After(5 * time.Second)
+	for !service.IsRunning() {
+		select {
+		case <-exited:
+			return fmt.Errorf("Supervisor for %s exited, see %s", service.Name, service.LogFilePath)
+		case <-deadline:
+			return fmt.Errorf("Supervisor for %s didn't start the service", service.Name)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	time.Sleep(200 * time.Millisecond)
+	if !service.IsRunning() {
+		return fmt.Errorf("Service %s exited after start, see %s", service.Name, service.LogFilePath)
+	}
+	return nil
+}
+
+// superviseService runs the service binary and waits for it. When it exits,
+// the restart policy decides if it has to be started again, after an
+// exponential backoff. Retries are reset once the service has been running
+// for longer than the maximum backoff.
+func superviseService(service *services.Service) error {
+	logFile, err := os.OpenFile(service.LogFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	// The orchestra binary may be reinstalled while the supervisor runs, so
+	// only its pid and start time are recorded
+	if err := services.WriteProcessState(service.SupervisorPidFilePath, os.Getpid(), ""); err != nil {
+		return err
+	}
+	defer os.Remove(service.SupervisorPidFilePath)
+	writeRestarts(service, 0)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	logFlag := os.O_TRUNC
+	restarts, retries := 0, 0
+	for {
+		started := time.Now()
+		success := false
+		proc, err := relaunchService(service, logFlag, restarts > 0)
+		logFlag = os.O_APPEND
+		if err != nil {
+			supervisorLog(logFile, "failed to start: %v", err)
+		} else {
+			select {
+			case sig := <-signals:
+				supervisorLog(logFile, "received %s, stopping", sig)
+				_, _ = killService(service)
+				<-proc.done
+				return nil
+			case <-proc.done:
+			}
+			success = proc.err == nil
+			supervisorLog(logFile, "exited: %s", exitStatus(proc.err))
+			if !success && service.HasHook(services.HookOnCrash) {
+				output := newPrefixWriter(logFile, "[orchestra supervisor] on_crash: ")
+				if err := service.RunHook(services.HookOnCrash, os.Environ(), output); err != nil {
+					supervisorLog(logFile, "%v", err)
+				}
+				output.Flush()
+			}
+		}
+
+		if time.Since(started) >= service.Restart.MaxBackoffOrDefault() {
+			retries = 0
+		}
+		if !service.Restart.ShouldRestart(success, retries) {
+			supervisorLog(logFile, "not restarting (policy %q, %d retries)", service.Restart.Policy, retries)
+			return nil
+		}
+		delay := service.Restart.Delay(retries)
+		retries++
+		restarts++
+		writeRestarts(service, restarts)
+		supervisorLog(logFile, "restarting in %s (restart #%d)", delay, restarts)
+
+		select {
+		case sig := <-signals:
+			supervisorLog(logFile, "received %s, stopping", sig)
+			return nil
+		case <-time.After(delay):
+		}
+	}
+}
+
+// relaunchService launches the service binary. Restarts take the service
+// lock, so that they don't interfere with a concurrent orchestra command;
+// the first launch is covered by the lock of the start command.
+func relaunchService(service *services.Service, logFlag int, restart bool) (*serviceProcess, error) {
+	if restart {
+		unlock, err := service.Lock(time.Minute, "orchestra supervise "+service.Name, nil)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+	}
+	return launchService(service, os.Environ(), false, logFlag, nil)
+}
+
+func supervisorLog(w io.Writer, format string, args ...interface{}) {
+	fmt.Fprintf(w, "[orchestra supervisor] "+format+"\n", args...)
+}
+
+func writeRestarts(service *services.Service, restarts int) {
+	service.Restarts = restarts
+	_ = os.WriteFile(service.RestartsFilePath, []byte(strconv.Itoa(restarts)), 0666)
+}
+
+func exitStatus(err error) string {
+	if err == nil {
+		return "exit status 0"
+	}
+	return err.Error()
+}
@@ -0,0 +1,251 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wsxiaoys/terminal"
+
+	"github.com/tifo/orchestra/config"
+	"github.com/tifo/orchestra/services"
+)
+
+var invalidUnitChars = regexp.MustCompile(`[^A-Za-z0-9:_.-]`)
+
+// unitPrefix returns the prefix of the units of the project, e.g.
+// orchestra-myproject
+func unitPrefix() string {
+	project := filepath.Base(filepath.Clean(services.ProjectPath))
+	return "orchestra-" + invalidUnitChars.ReplaceAllString(project, "_")
+}
+
+// unitName returns the name of the unit of a service instance, the stack
+// separator and the index of the instance are written with dashes
+func unitName(instance *services.Service) string {
+	return unitPrefix() + "-" + instanceUnitName(instance)
+}
+
+func instanceUnitName(instance *services.Service) string {
+	name := strings.NewReplacer("/", "-", "#", "-").Replace(instance.Name)
+	return invalidUnitChars.ReplaceAllString(name, "_")
+}
+
+// unitNames returns the names of the units of all the instances of a service
+func unitNames(service *services.Service, suffix string) []string {
+	names := make([]string, 0, service.Scale)
+	for _, instance := range service.Instances(service.Scale) {
+		names = append(names, unitName(instance)+suffix)
+	}
+	return names
+}
+
+// stackTarget returns the target grouping the services of a stack, or all
+// the services of the project
+func stackTarget(stack string) string {
+	if stack == "" {
+		return unitPrefix() + ".target"
+	}
+	return unitPrefix() + "-" + invalidUnitChars.ReplaceAllString(strings.Replace(stack, "/", "-", -1), "_") + ".target"
+}
+
+// exportSystemd writes a systemd user unit for every service instance, a
+// target for every stack and a target for the whole project, which wants the
+// others. Units carry the environment, working directory, command line,
+// hooks, restart policy, stop signal and resources of the services, and the
+// services depending on others are ordered after them.
+func exportSystemd(svcs map[string]*services.Service, out string) {
+	if err := os.MkdirAll(out, 0755); err != nil {
+		appendError(err)
+		terminal.Stdout.Colorf("@{r}error: @{|}%v\n", err)
+		return
+	}
+
+	stacks := make(map[string][]string)
+	for _, service := range services.Sort(svcs) {
+		stacks[service.Stack] = append(stacks[service.Stack], unitNames(service, ".service")...)
+		target := stackTarget(service.Stack)
+		deps := exportedDependencies(service, svcs)
+		for _, instance := range service.Instances(service.Scale) {
+			name := unitName(instance) + ".service"
+			writeUnit(filepath.Join(out, name), instance.Name, systemdService(service, instance, target, deps))
+		}
+	}
+
+	project := filepath.Base(filepath.Clean(services.ProjectPath))
+	stackNames := make([]string, 0, len(stacks))
+	for stack := range stacks {
+		stackNames = append(stackNames, stack)
+	}
+	sort.Strings(stackNames)
+	wants := stacks[""]
+	for _, stack := range stackNames {
+		if stack == "" {
+			continue
+		}
+		wants = append(wants, stackTarget(stack))
+		unit := &strings.Builder{}
+		fmt.Fprintf(unit, "# Generated by orchestra export from %s\n", config.ConfigPath)
+		fmt.Fprintf(unit, "[Unit]\nDescription=Stack %s of %s (orchestra)\n", stack, project)
+		fmt.Fprintf(unit, "PartOf=%s\n", stackTarget(""))
+		fmt.Fprintf(unit, "Wants=%s\n", strings.Join(stacks[stack], " "))
+		writeUnit(filepath.Join(out, stackTarget(stack)), stack, unit.String())
+	}
+	unit := &strings.Builder{}
+	fmt.Fprintf(unit, "# Generated by orchestra export from %s\n", config.ConfigPath)
+	fmt.Fprintf(unit, "[Unit]\nDescription=%s (orchestra)\n", project)
+	fmt.Fprintf(unit, "Wants=%s\n", strings.Join(wants, " "))
+	fmt.Fprintf(unit, "\n[Install]\nWantedBy=default.target\n")
+	writeUnit(filepath.Join(out, stackTarget("")), project, unit.String())
+}
+
+func systemdService(service, instance *services.Service, target string, exportedDeps []*services.Service) string {
+	env := getStartEnvForService(instance)
+	program, args, dir := instance.CommandLine(env)
+
+	unit := &strings.Builder{}
+	fmt.Fprintf(unit, "# Generated by orchestra export from %s\n", config.ConfigPath)
+	fmt.Fprintf(unit, "[Unit]\nDescription=%s of %s (orchestra)\n", instance.Name, filepath.Base(filepath.Clean(services.ProjectPath)))
+	fmt.Fprintf(unit, "PartOf=%s\n", target)
+	deps := make([]string, 0, len(service.Dependencies))
+	for _, dep := range exportedDeps {
+		deps = append(deps, unitNames(dep, ".service")...)
+	}
+	if len(deps) > 0 {
+		fmt.Fprintf(unit, "Requires=%s\nAfter=%s\n", strings.Join(deps, " "), strings.Join(deps, " "))
+	}
+
+	fmt.Fprintf(unit, "\n[Service]\nType=simple\n")
+	fmt.Fprintf(unit, "WorkingDirectory=%s\n", strings.Replace(filepath.Clean(dir), "%", "%%", -1))
+	vars := serviceEnvVars(instance)
+	for _, name := range sortedKeys(vars) {
+		fmt.Fprintf(unit, "Environment=%s\n", systemdQuote(name+"="+vars[name], false))
+	}
+	systemdHooks(unit, "ExecStartPre", service.Hooks.PreStart, dir)
+	words := []string{systemdQuote(program, true)}
+	for _, arg := range args {
+		words = append(words, systemdQuote(arg, true))
+	}
+	fmt.Fprintf(unit, "ExecStart=%s\n", strings.Join(words, " "))
+	systemdHooks(unit, "ExecStartPost", service.Hooks.PostStart, dir)
+	systemdHooks(unit, "ExecStop", service.Hooks.PreStop, dir)
+	systemdHooks(unit, "ExecStopPost", service.Hooks.PostStop, dir)
+
+	switch service.Restart.Policy {
+	case services.RestartNever:
+		fmt.Fprintf(unit, "Restart=no\n")
+	case services.RestartAlways:
+		fmt.Fprintf(unit, "Restart=always\n")
+	default:
+		fmt.Fprintf(unit, "Restart=on-failure\n")
+	}
+	fmt.Fprintf(unit, "RestartSec=%s\n", systemdSeconds(service.Restart.Delay(0)))
+	fmt.Fprintf(unit, "KillSignal=%s\n", services.SignalName(service.StopSignal))
+	if service.StopTimeout > 0 {
+		fmt.Fprintf(unit, "TimeoutStopSec=%s\n", systemdSeconds(service.StopTimeout))
+	}
+
+	if r := service.Resources; r != nil {
+		if r.Memory > 0 {
+			fmt.Fprintf(unit, "MemoryMax=%d\n", r.Memory)
+		}
+		if r.CPU > 0 {
+			fmt.Fprintf(unit, "CPUQuota=%s%%\n", strconv.FormatFloat(r.CPU*100, 'f', -1, 64))
+		}
+		if r.NoFile > 0 {
+			fmt.Fprintf(unit, "LimitNOFILE=%d\n", r.NoFile)
+		}
+		if r.Nice != 0 {
+			fmt.Fprintf(unit, "Nice=%d\n", r.Nice)
+		}
+		if r.OOMScoreAdj != 0 {
+			fmt.Fprintf(unit, "OOMScoreAdjust=%d\n", r.OOMScoreAdj)
+		}
+	}
+	return unit.String()
+}
+
+// systemdHooks writes a hook as commands run by the shell, as systemd runs
+// them from the working directory of the service. Hooks which may fail are
+// prefixed with `-`.
+func systemdHooks(unit *strings.Builder, key string, hooks config.Hooks, dir string) {
+	for _, hook := range hooks {
+		script := hook.Shell
+		if script == "" {
+			quoted := make([]string, 0, len(hook.Argv))
+			for _, arg := range hook.Argv {
+				quoted = append(quoted, "'"+strings.Replace(arg, "'", `'\''`, -1)+"'")
+			}
+			script = "exec " + strings.Join(quoted, " ")
+		}
+		if hook.WorkDir != "" {
+			workDir := hook.WorkDir
+			if !filepath.IsAbs(workDir) {
+				workDir = filepath.Join(dir, workDir)
+			}
+			script = "cd '" + strings.Replace(workDir, "'", `'\''`, -1) + "' && " + script
+		}
+		prefix := ""
+		if hook.OnFailure == config.OnFailureContinue {
+			prefix = "-"
+		}
+		fmt.Fprintf(unit, "%s=%s/bin/sh -c %s\n", key, prefix, systemdQuote(script, true))
+	}
+}
+
+// systemdQuote quotes a value for a unit file. Specifiers are escaped, and
+// so are variables in command lines, which systemd would expand.
+func systemdQuote(value string, command bool) string {
+	replacements := []string{`\`, `\\`, `"`, `\"`, "\n", `\n`, "%", "%%"}
+	if command {
+		replacements = append(replacements, "$", "$$")
+	}
+	return `"` + strings.NewReplacer(replacements...).Replace(value) + `"`
+}
+
+func systemdSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+}
+
+// dependencyMap returns the dependencies of a service, by name
+func dependencyMap(service *services.Service) map[string]*services.Service {
+	deps := make(map[string]*services.Service, len(service.Dependencies))
+	for _, dep := range service.Dependencies {
+		deps[dep.Name] = dep
+	}
+	return deps
+}
+
+// exportedDependencies returns the dependencies of a service exported along
+// with it, sorted by name. The others are left out with a warning, as the
+// export can't refer to them.
+func exportedDependencies(service *services.Service, svcs map[string]*services.Service) []*services.Service {
+	deps := make([]*services.Service, 0, len(service.Dependencies))
+	for _, dep := range services.Sort(dependencyMap(service)) {
+		if _, ok := svcs[dep.Name]; ok {
+			deps = append(deps, dep)
+			continue
+		}
+		spacing := strings.Repeat(" ", services.MaxServiceNameLength+2-len(service.Name))
+		terminal.Stderr.Colorf("%s%s| @{y} warning: @{|}dependency %s isn't exported, left out\n", service.Name, spacing, dep.Name)
+	}
+	return deps
+}
+
+func writeUnit(path, name, content string) {
+	spacing := strings.Repeat(" ", services.MaxServiceNameLength+2-len(name))
+	if len(name) > services.MaxServiceNameLength {
+		spacing = "  "
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		appendError(err)
+		terminal.Stdout.Colorf("%s%s| @{r} error: @{|}%v\n", name, spacing, err)
+		return
+	}
+	terminal.Stdout.Colorf("%s%s| @{g} exported@{|} (%s)\n", name, spacing, path)
+}
@@ -34,6 +34,10 @@ func TestAction(c *cli.Context) error {
 	svcs := services.Sort(FilterServices(c))
 	for _, service := range svcs {
 		spacing := strings.Repeat(" ", services.MaxServiceNameLength+2-len(service.Name))
+		if service.Type == services.TypeExec && service.TestCommand == "" {
+			terminal.Stdout.Colorf("%s%s| @{y} skipped@{|} (exec service without a test command)\n", service.Name, spacing)
+			continue
+		}
 		success, err := testService(c, service)
 		if err != nil {
 			appendError(err)
@@ -53,6 +57,12 @@ func TestAction(c *cli.Context) error {
 // variables for the command and starts it. If cmd.Start() doesn't return any
 // error, it will write a service.pid file in .orchestra
 func testService(c *cli.Context, service *services.Service) (bool, error) {
+	if service.Type == services.TypeExec {
+		if service.TestCommand == "" {
+			return true, nil
+		}
+		return runServiceCommand(c, service, service.TestCommand, os.Stdout) == nil, nil
+	}
 	cmdArgs := []string{"test"}
 	if c.Bool("verbose") {
 		cmdArgs = append(cmdArgs, "-v")
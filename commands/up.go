@@ -0,0 +1,276 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/urfave/cli/v2"
+	"github.com/wsxiaoys/terminal"
+	"github.com/wsxiaoys/terminal/color"
+
+	"github.com/tifo/orchestra/services"
+	"github.com/tifo/orchestra/vendors"
+)
+
+var UpCommand = &cli.Command{
+	Name:         "up",
+	Usage:        "Runs all the services in the foreground, until one of them exits or Ctrl-C",
+	Action:       BeforeAfterWrapper(UpAction),
+	BashComplete: ServicesBashComplete,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "keep-going",
+			Usage: "Keep the other services running when a service exits",
+		},
+		&cli.BoolFlag{
+			Name:  "with-deps",
+			Usage: "Also run the dependencies of the services",
+		},
+		&cli.BoolFlag{
+			Name:  "no-vendors",
+			Usage: "Don't start the vendors before the services",
+		},
+		&cli.StringSliceFlag{
+			Name:  "scale",
+			Usage: "Number of instances to run for a service, as <service>=<instances>",
+		},
+	},
+}
+
+// upInstance is a service instance started by up, and its process
+type upInstance struct {
+	instance *services.Service
+	proc     *serviceProcess
+}
+
+// UpAction starts all the services (or the specified ones) like start, as
+// children of orchestra and with their output prefixed on the terminal. They
+// run until SIGINT or SIGTERM, or until one of them exits (unless
+// --keep-going), then orchestra stops them all in reverse dependency order.
+func UpAction(c *cli.Context) error {
+	svcs := FilterServices(c)
+	if c.Bool("with-deps") {
+		svcs = services.WithDependencies(svcs)
+	}
+	if err := applyScaleFlag(c, svcs); err != nil {
+		appendError(err)
+		terminal.Stdout.Colorf("@{r}error: @{|}%v\n", err)
+		return nil
+	}
+	waves, err := services.Waves(svcs)
+	if err != nil {
+		appendError(err)
+		terminal.Stdout.Colorf("@{r}error: @{|}%v\n", err)
+		return nil
+	}
+
+	// The services may need any of the vendors, which are kept running
+	if len(vendors.Registry) > 0 && !c.Bool("no-vendors") {
+		runtime, err := vendorsRuntime()
+		if err != nil || !startVendors(runtime, vendors.Sort(vendors.Registry)) {
+			return nil
+		}
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	// A signal during the startup stops it after the services being started,
+	// the ones already up are then stopped
+	interrupted := make(chan struct{})
+	startup := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-signals:
+			terminal.Stdout.Colorf("@{y}received %s, stopping\n", services.SignalName(sig.(syscall.Signal)))
+			close(interrupted)
+		case <-startup:
+		}
+	}()
+
+	// Every instance reports its exit, whether it is waited for or not
+	count := 0
+	for _, service := range svcs {
+		count += len(service.Instances(service.Scale))
+	}
+	var mu sync.Mutex
+	started := make(map[string][]*upInstance)
+	exited := make(chan *upInstance, count)
+	ok := true
+	runInWavesUntil(waves, interrupted, func(service *services.Service) bool {
+		instances, success := up(c, service, svcs, interrupted)
+		mu.Lock()
+		started[service.Name] = instances
+		ok = ok && success
+		mu.Unlock()
+		for _, instance := range instances {
+			go func(instance *upInstance) {
+				<-instance.proc.done
+				exited <- instance
+			}(instance)
+		}
+		return success
+	})
+	close(startup)
+
+	remaining := 0
+	for _, instances := range started {
+		remaining += len(instances)
+	}
+	select {
+	case <-interrupted:
+		remaining = 0
+	default:
+		if !ok && !c.Bool("keep-going") {
+			remaining = 0
+		}
+	}
+	for remaining > 0 {
+		select {
+		case sig := <-signals:
+			terminal.Stdout.Colorf("@{y}received %s, stopping\n", services.SignalName(sig.(syscall.Signal)))
+			remaining = 0
+		case instance := <-exited:
+			remaining--
+			reportExit(c, instance)
+			if !c.Bool("keep-going") {
+				remaining = 0
+			}
+		}
+	}
+	stopUp(c, waves, started)
+	return nil
+}
+
+// up starts the instances of a service with their output on the terminal,
+// the instances already running are left alone. Waiting for them to be
+// healthy stops once stop is closed.
+func up(c *cli.Context, service *services.Service, svcs map[string]*services.Service, stop <-chan struct{}) ([]*upInstance, bool) {
+	spacing := strings.Repeat(" ", services.MaxServiceNameLength+2-len(service.Name))
+	unlock, err := lockService(c, service)
+	if err != nil {
+		appendError(err)
+		terminal.Stdout.Colorf("%s%s| @{r} error: @{|}%v\n", service.Name, spacing, err)
+		return nil, false
+	}
+	defer unlock()
+
+	pending := make([]*services.Service, 0)
+	for _, instance := range service.Instances(service.Scale) {
+		if instance.Process != nil {
+			instanceSpacing := strings.Repeat(" ", services.MaxServiceNameLength+2-len(instance.Name))
+			terminal.Stdout.Colorf("%s%s| @{c} already running@{|} (not managed by up)\n", instance.Name, instanceSpacing)
+		} else {
+			pending = append(pending, instance)
+		}
+	}
+	if len(pending) == 0 {
+		return nil, true
+	}
+
+	err = checkDependencies(c, service, svcs, stop)
+	if err == nil {
+		_, err = prepareService(c, service, nil)
+	}
+	if err != nil {
+		appendError(err)
+		terminal.Stdout.Colorf("%s%s| @{r} error: @{|}%v\n", service.Name, spacing, err)
+		return nil, false
+	}
+	if !reportHook(c, service, services.HookPreStart) {
+		return nil, false
+	}
+
+	var mu sync.Mutex
+	instances := make([]*upInstance, 0, len(pending))
+	success := forEachInstance(pending, func(instance *services.Service) bool {
+		instanceSpacing := strings.Repeat(" ", services.MaxServiceNameLength+2-len(instance.Name))
+		output := newPrefixWriter(os.Stdout, color.Sprint(fmt.Sprintf("@{%s}%s@{|}%s|  ", instance.Color, instance.Name, instanceSpacing)))
+		proc, err := startProcess(c, instance, output)
+		if err == nil {
+			mu.Lock()
+			instances = append(instances, &upInstance{instance: instance, proc: proc})
+			mu.Unlock()
+			err = instance.WaitHealthy(stop, GetEnvForService(c, instance))
+		}
+		if err != nil {
+			appendError(err)
+			terminal.Stdout.Colorf("%s%s| @{r} error: @{|}%v\n", instance.Name, instanceSpacing, err)
+			return false
+		}
+		terminal.Stdout.Colorf("%s%s| @{g} started\n", instance.Name, instanceSpacing)
+		return true
+	})
+	return instances, success && reportHook(c, service, services.HookPostStart)
+}
+
+// reportExit reports an instance which exited on its own, and cleans up
+// after it. Services run by up are expected to run until orchestra stops
+// them, so any exit is an error, a non-zero one is also a crash.
+func reportExit(c *cli.Context, instance *upInstance) {
+	name := instance.instance.Name
+	spacing := strings.Repeat(" ", services.MaxServiceNameLength+2-len(name))
+	os.Remove(instance.instance.PidFilePath)
+	instance.instance.ReleaseResources()
+	instance.instance.Process = nil
+
+	err := fmt.Errorf("Service %s exited: %s", name, exitStatus(instance.proc.err))
+	appendError(err)
+	terminal.Stdout.Colorf("%s%s| @{r} error: @{|}%v\n", name, spacing, err)
+	if instance.proc.err != nil {
+		reportHook(c, instance.instance, services.HookOnCrash)
+	}
+}
+
+// stopUp stops the instances started by up which are still running, in
+// reverse dependency order, and waits for all of them to exit
+func stopUp(c *cli.Context, waves [][]*services.Service, started map[string][]*upInstance) {
+	for i := len(waves) - 1; i >= 0; i-- {
+		var wg sync.WaitGroup
+		for _, service := range waves[i] {
+			instances := started[service.Name]
+			if len(instances) == 0 {
+				continue
+			}
+			wg.Add(1)
+			go func(service *services.Service, instances []*upInstance) {
+				defer wg.Done()
+				stopUpService(c, service, instances)
+			}(service, instances)
+		}
+		wg.Wait()
+	}
+}
+
+func stopUpService(c *cli.Context, service *services.Service, instances []*upInstance) {
+	running := make([]*services.Service, 0, len(instances))
+	for _, instance := range instances {
+		if instance.instance.Process != nil {
+			running = append(running, instance.instance)
+		}
+	}
+	if len(running) > 0 {
+		unlock, err := lockService(c, service)
+		if err != nil {
+			appendError(err)
+			spacing := strings.Repeat(" ", services.MaxServiceNameLength+2-len(service.Name))
+			terminal.Stdout.Colorf("%s%s| @{r} error: @{|}%v\n", service.Name, spacing, err)
+			return
+		}
+		defer unlock()
+		reportHook(c, service, services.HookPreStop)
+		forEachInstance(running, func(instance *services.Service) bool {
+			stop(instance)
+			return true
+		})
+		reportHook(c, service, services.HookPostStop)
+	}
+	for _, instance := range instances {
+		<-instance.proc.done
+	}
+}
@@ -0,0 +1,30 @@
+package commands
+
+import "testing"
+
+func TestUpExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		code string
+		want int
+	}{
+		{"exit status 0", "0", 1},
+		{"crash", "3", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The service exits once up has started it and runs its
+			// post_start hook, so that up is waiting for the services
+			testProject(t, map[string]string{
+				"worker/service.yml": "type: exec\n" +
+					"command: sh\n" +
+					"args: [-c, 'while [ ! -e started ]; do sleep 0.01; done; exit " + tt.code + "']\n" +
+					"post_start: touch started\n",
+			})
+			runCommand(t, UpCommand)
+			if got := ExitCode(); got != tt.want {
+				t.Errorf("exit code = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
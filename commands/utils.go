@@ -2,14 +2,23 @@ package commands
 
 import (
 	"fmt"
+	"io"
 	"math"
 	"os"
+	"os/exec"
+	"os/signal"
 	"path"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 
 	log "github.com/cihub/seelog"
 	"github.com/urfave/cli/v2"
+	"github.com/wsxiaoys/terminal"
 
 	"github.com/tifo/orchestra/config"
 	"github.com/tifo/orchestra/services"
@@ -21,9 +30,34 @@ const niceness = "1"
 
 // This is temporary, very very alpha and may change soon
 func FilterServices(c *cli.Context) map[string]*services.Service {
+	return filterServices(c.Args().Slice(), scaleFlag(c))
+}
+
+// scaleFlag reads the instances asked for with --scale, so that svc#N can
+// select instances about to be started. applyScaleFlag reports the invalid
+// values.
+func scaleFlag(c *cli.Context) map[string]int {
+	scales := make(map[string]int)
+	for _, value := range c.StringSlice("scale") {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if n, err := strconv.Atoi(parts[1]); err == nil {
+			scales[parts[0]] = n
+		}
+	}
+	return scales
+}
+
+// filterServices selects services from command line arguments: services,
+// stacks, `~` exclusions and `svc#N` instances. An instance has to exist
+// within the service scale (or the one in scales) or be still running.
+func filterServices(args []string, scales map[string]int) map[string]*services.Service {
 	excludeMode := 0
-	args := c.Args().Slice()
 	included := make(map[string]bool)
+	// Services selected as a whole, whose instances aren't narrowed down
+	whole := make(map[string]bool)
 
 	for _, s := range args {
 		name := s
@@ -37,8 +71,28 @@ func FilterServices(c *cli.Context) map[string]*services.Service {
 			cwd, _ := os.Getwd()
 			name, _ = filepath.Rel(services.ProjectPath, cwd)
 		}
+		// Select a single instance of a scaled service with svc#N
+		instance := 0
+		if i := strings.LastIndex(name, "#"); i >= 0 {
+			n, err := strconv.Atoi(name[i+1:])
+			if err != nil || n < 1 || strings.HasPrefix(s, "~") {
+				_ = log.Errorf("Invalid service instance %s", name)
+				return nil
+			}
+			name, instance = name[:i], n
+		}
 		// Check if arg match a service or a stack
-		if _, ok := services.Registry[name]; ok {
+		if service, ok := services.Registry[name]; ok {
+			if count := instanceLimit(service, scales); instance > count {
+				_ = log.Errorf("Invalid service instance %s, %s has %d instance(s)", s, name, count)
+				return nil
+			}
+			if instance == 0 {
+				whole[name] = true
+				service.OnlyInstances = nil
+			} else if !whole[name] && !containsInt(service.OnlyInstances, instance) {
+				service.OnlyInstances = append(service.OnlyInstances, instance)
+			}
 			if strings.HasPrefix(s, "~") {
 				excludeMode += 1
 				delete(services.Registry, name)
@@ -47,6 +101,10 @@ func FilterServices(c *cli.Context) map[string]*services.Service {
 				included[name] = true
 			}
 		} else if stack, ok := services.StackRegistry[name]; ok {
+			if instance > 0 {
+				_ = log.Errorf("Invalid service instance %s, %s is a stack", s, name)
+				return nil
+			}
 			if strings.HasPrefix(s, "~") {
 				excludeMode += 1
 				for _, svc := range stack {
@@ -78,11 +136,42 @@ func FilterServices(c *cli.Context) map[string]*services.Service {
 	return services.Registry
 }
 
+// instanceLimit returns the number of instances of a service which can be
+// selected with svc#N
+func instanceLimit(service *services.Service, scales map[string]int) int {
+	count := service.InstanceCount()
+	if scale := scales[service.Name]; scale > count {
+		count = scale
+	}
+	if count < 1 {
+		count = 1
+	}
+	return count
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// BeforeAfterWrapper runs the before hooks, the command and the after hooks.
+// A failing before hook, or env file of the command, aborts the command.
 func BeforeAfterWrapper(f func(c *cli.Context) error) func(c *cli.Context) error {
 	return func(c *cli.Context) error {
+		if err := config.LoadCommandEnv(c); err != nil {
+			appendError(err)
+			terminal.Stdout.Colorf("@{r}error: @{|}%v, aborting\n", err)
+			return nil
+		}
 		err := config.GetBeforeFunc()(c)
 		if err != nil {
 			appendError(err)
+			terminal.Stdout.Colorf("@{r}error: @{|}before hook %v, aborting\n", err)
+			return nil
 		}
 		_ = f(c)
 		err = config.GetAfterFunc()(c)
@@ -109,9 +198,227 @@ func ServicesBashComplete(c *cli.Context) {
 }
 
 // GetEnvForService returns all the environment variables for a given service
-// including the ones specified in the global config
+// including the ones specified in the global config. The variables of the
+// instance come last so that they take precedence.
 func GetEnvForService(c *cli.Context, service *services.Service) []string {
-	return append(service.Env, config.GetEnvForCommand(c)...)
+	return serviceEnv(service, config.GetEnvForCommand(c))
+}
+
+// getStartEnvForService returns the environment the service is started with,
+// whichever command asks for it
+func getStartEnvForService(service *services.Service) []string {
+	return serviceEnv(service, config.GetEnvForCommandName("start"))
+}
+
+func serviceEnv(service *services.Service, commandEnv []string) []string {
+	env := make([]string, 0, len(service.Env))
+	env = append(env, service.Env...)
+	env = append(env, commandEnv...)
+	return append(env, service.InstanceEnv()...)
+}
+
+// singleService returns the service, or the instance with svc#N, named on the
+// command line, and reports an error when it isn't a single service
+func singleService(name string, out *terminal.TerminalWriter) *services.Service {
+	svcs := filterServices([]string{name}, nil)
+	if svcs == nil {
+		// filterServices already reported the unknown service
+		appendError(fmt.Errorf("Service %s not found", name))
+		return nil
+	}
+	if len(svcs) != 1 {
+		err := fmt.Errorf("%s isn't a single service", name)
+		appendError(err)
+		out.Colorf("@{r}error: @{|}%v\n", err)
+		return nil
+	}
+	var service *services.Service
+	for _, svc := range svcs {
+		service = svc
+	}
+	if len(service.OnlyInstances) > 0 {
+		service = service.Instances(1)[0]
+	}
+	return service
+}
+
+// serviceEnvVars returns the variables orchestra sets for a service, from
+// orchestra.yml, service.yml and its instance, with the values the service
+// is started with. The rest of the environment of orchestra is left out.
+func serviceEnvVars(service *services.Service) map[string]string {
+	values := envMap(getStartEnvForService(service))
+	vars := make(map[string]string)
+	for name := range config.GetEnvVarsForCommandName("start") {
+		vars[name] = values[name]
+	}
+	own := append(append([]string{}, service.Env...), service.InstanceEnv()...)
+	for name := range envMap(own) {
+		vars[name] = values[name]
+	}
+	return vars
+}
+
+// envMap returns the value of every variable of an environment, the later
+// entries overriding the earlier ones
+func envMap(env []string) map[string]string {
+	vars := make(map[string]string, len(env))
+	for _, kv := range env {
+		if i := strings.Index(kv, "="); i > 0 {
+			vars[kv[:i]] = kv[i+1:]
+		}
+	}
+	return vars
+}
+
+// sortedKeys returns the names of the variables in order
+func sortedKeys(vars map[string]string) []string {
+	keys := make([]string, 0, len(vars))
+	for key := range vars {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// runServiceCommand runs a shell command of an exec service (e.g. its build
+// command) in the service directory, with the service environment
+func runServiceCommand(c *cli.Context, service *services.Service, command string, output io.Writer) error {
+	cmd := exec.Command("nice", "-n", niceness, "sh", "-c", command)
+	cmd.Dir = service.Path
+	cmd.Stdout = output
+	cmd.Stderr = output
+	cmd.Env = GetEnvForService(c, service)
+	return cmd.Run()
+}
+
+// instancesOf expands every service into its instances
+func instancesOf(svcs []*services.Service) []*services.Service {
+	instances := make([]*services.Service, 0, len(svcs))
+	for _, service := range svcs {
+		instances = append(instances, service.Instances(service.InstanceCount())...)
+	}
+	return instances
+}
+
+// anyRunning tells if any of the instances is running or supervised
+func anyRunning(instances []*services.Service) bool {
+	for _, instance := range instances {
+		if instance.Process != nil || instance.Supervisor != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// interruptChannel returns a channel closed on SIGINT or SIGTERM, so that
+// waits can give up, and a function to stop listening for the signals. The
+// signals after the first one have their default effect again.
+func interruptChannel() (<-chan struct{}, func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	interrupted := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-signals:
+			signal.Stop(signals)
+			terminal.Stdout.Colorf("@{y}received %s, stopping\n", services.SignalName(sig.(syscall.Signal)))
+			close(interrupted)
+		case <-done:
+		}
+	}()
+	return interrupted, func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+// runInWaves calls f for every service, wave after wave and in parallel
+// within a wave. Services with a dependency for which f returned false are
+// not run, and reported as failed.
+func runInWaves(waves [][]*services.Service, f func(service *services.Service) bool) {
+	runInWavesUntil(waves, nil, f)
+}
+
+// runInWavesUntil is runInWaves, giving up on the services not started yet
+// once stop is closed
+func runInWavesUntil(waves [][]*services.Service, stop <-chan struct{}, f func(service *services.Service) bool) {
+	stopped := func() bool {
+		select {
+		case <-stop:
+			return true
+		default:
+			return false
+		}
+	}
+	failed := make(map[string]bool)
+	var mu sync.Mutex
+	for _, wave := range waves {
+		if stopped() {
+			return
+		}
+		ready := make([]*services.Service, 0, len(wave))
+		for _, service := range wave {
+			if dep := failedDependency(service, failed); dep != "" {
+				spacing := strings.Repeat(" ", services.MaxServiceNameLength+2-len(service.Name))
+				err := fmt.Errorf("Dependency %s of %s failed", dep, service.Name)
+				appendError(err)
+				terminal.Stdout.Colorf("%s%s| @{r} error: @{|}%v\n", service.Name, spacing, err)
+				failed[service.Name] = true
+			} else {
+				ready = append(ready, service)
+			}
+		}
+
+		pool := make(workerPool, runtime.NumCPU())
+		for _, service := range ready {
+			service := service
+			pool.Do(func() {
+				if stopped() {
+					return
+				}
+				if !f(service) {
+					mu.Lock()
+					failed[service.Name] = true
+					mu.Unlock()
+				}
+			})
+		}
+		pool.Drain()
+	}
+}
+
+func failedDependency(service *services.Service, failed map[string]bool) string {
+	for _, dep := range service.Dependencies {
+		if failed[dep.Name] {
+			return dep.Name
+		}
+	}
+	return ""
+}
+
+// reverseWaves returns the services in reverse dependency order, so that
+// dependents are handled before their dependencies. With a dependency cycle,
+// all the services end up in a single wave.
+func reverseWaves(svcs map[string]*services.Service) [][]*services.Service {
+	waves, err := services.Waves(svcs)
+	if err != nil {
+		return [][]*services.Service{services.Sort(svcs)}
+	}
+	for i, j := 0, len(waves)-1; i < j; i, j = i+1, j-1 {
+		waves[i], waves[j] = waves[j], waves[i]
+	}
+	return waves
+}
+
+// lockService takes the lock of a service for the running command, so that
+// concurrent orchestra invocations don't start or stop it at the same time
+func lockService(c *cli.Context, service *services.Service) (func(), error) {
+	command := "orchestra " + strings.Join(os.Args[1:], " ")
+	return service.Lock(c.Duration("lock-timeout"), command, func(holder string) {
+		spacing := strings.Repeat(" ", services.MaxServiceNameLength+2-len(service.Name))
+		terminal.Stdout.Colorf("%s%s| @{y} waiting: @{|}locked by %s\n", service.Name, spacing, holder)
+	})
 }
 
 type workerPool chan struct{}
@@ -0,0 +1,61 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/tifo/orchestra/config"
+	"github.com/tifo/orchestra/services"
+)
+
+// testProject writes the files of a project (orchestra.yml and the service
+// directories, by path), discovers its services and resets the errors and
+// exit code of the previous commands
+func testProject(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	if _, ok := files["orchestra.yml"]; !ok {
+		files["orchestra.yml"] = ""
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config.ConfigPath = filepath.Join(dir, "orchestra.yml")
+	services.ProjectPath = dir + "/"
+	services.OrchestraServicePath = filepath.Join(dir, ".orchestra")
+	if err := os.Mkdir(services.OrchestraServicePath, 0766); err != nil {
+		t.Fatal(err)
+	}
+	config.ParseGlobalConfig()
+	services.Registry = make(map[string]*services.Service)
+	services.StackRegistry = make(map[string][]*services.Service)
+	services.Init()
+
+	errorBucket, exitCode = nil, 0
+	t.Cleanup(func() { errorBucket, exitCode = nil, 0 })
+	return dir
+}
+
+// runCommand runs an orchestra command with its arguments
+func runCommand(t *testing.T, command *cli.Command, args ...string) {
+	app := &cli.App{
+		Name: "orchestra",
+		Flags: []cli.Flag{
+			&cli.DurationFlag{Name: "lock-timeout", Value: time.Second},
+		},
+		Commands: []*cli.Command{command},
+	}
+	if err := app.Run(append([]string{"orchestra", command.Name}, args...)); err != nil {
+		t.Fatalf("orchestra %s %s: %v", command.Name, strings.Join(args, " "), err)
+	}
+}
@@ -0,0 +1,211 @@
+package commands
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/urfave/cli/v2"
+	"github.com/wsxiaoys/terminal"
+
+	"github.com/tifo/orchestra/config"
+	"github.com/tifo/orchestra/services"
+	"github.com/tifo/orchestra/vendors"
+)
+
+var VendorsCommand = &cli.Command{
+	Name:  "vendors",
+	Usage: "Manages the vendors (postgres, rabbitmq, …) running in containers",
+	Subcommands: []*cli.Command{
+		{
+			Name:         "up",
+			Usage:        "Starts all the vendors (or the specified ones)",
+			Action:       BeforeAfterWrapper(VendorsUpAction),
+			BashComplete: VendorsBashComplete,
+		},
+		{
+			Name:         "down",
+			Usage:        "Stops and removes all the vendors (or the specified ones)",
+			Action:       BeforeAfterWrapper(VendorsDownAction),
+			BashComplete: VendorsBashComplete,
+			Flags: []cli.Flag{
+				&cli.DurationFlag{
+					Name:  "timeout",
+					Usage: "How long to wait for a vendor to stop before killing it",
+					Value: 10 * time.Second,
+				},
+			},
+		},
+		{
+			Name:         "ps",
+			Usage:        "Outputs the status of the vendors",
+			Action:       BeforeAfterWrapper(VendorsPsAction),
+			BashComplete: VendorsBashComplete,
+		},
+	},
+}
+
+// VendorsUpAction starts the vendors that aren't running
+func VendorsUpAction(c *cli.Context) error {
+	runtime, err := vendorsRuntime()
+	if err != nil {
+		return err
+	}
+	startVendors(runtime, vendors.Sort(FilterVendors(c)))
+	return nil
+}
+
+// VendorsDownAction stops and removes the containers of the vendors
+func VendorsDownAction(c *cli.Context) error {
+	runtime, err := vendorsRuntime()
+	if err != nil {
+		return err
+	}
+	for _, vendor := range vendors.Sort(FilterVendors(c)) {
+		spacing := strings.Repeat(" ", services.MaxServiceNameLength+2-len(vendor.Name))
+		if err := runtime.Stop(vendor, c.Duration("timeout")); err != nil {
+			appendError(err)
+			terminal.Stdout.Colorf("%s%s| @{r} error: @{|}%v\n", vendor.Name, spacing, err)
+			continue
+		}
+		terminal.Stdout.Colorf("%s%s| @{r} stopped\n", vendor.Name, spacing)
+	}
+	return nil
+}
+
+// VendorsPsAction outputs the status of the vendors
+func VendorsPsAction(c *cli.Context) error {
+	runtime, err := vendorsRuntime()
+	if err != nil {
+		return err
+	}
+	printVendors(runtime, vendors.Sort(FilterVendors(c)))
+	return nil
+}
+
+// FilterVendors returns all the vendors, or the ones specified
+func FilterVendors(c *cli.Context) map[string]*vendors.Vendor {
+	if c.Args().Len() == 0 {
+		return vendors.Registry
+	}
+	filtered := make(map[string]*vendors.Vendor)
+	for _, name := range c.Args().Slice() {
+		vendor, ok := vendors.Registry[name]
+		if !ok {
+			_ = log.Errorf("Vendor %s not found", name)
+			return nil
+		}
+		filtered[name] = vendor
+	}
+	return filtered
+}
+
+func VendorsBashComplete(c *cli.Context) {
+	confVal := config.FindProjectConfig(c.String("config"))
+	config.ConfigPath, _ = filepath.Abs(confVal)
+	config.ParseGlobalConfig()
+	for name := range config.GetVendors() {
+		fmt.Println(name)
+	}
+}
+
+// vendorsRuntime returns the container runtime, the error is recorded
+func vendorsRuntime() (vendors.Runtime, error) {
+	runtime, err := vendors.NewRuntime(config.GetRuntime())
+	if err != nil {
+		appendError(err)
+		terminal.Stdout.Colorf("@{r}error: @{|}%v\n", err)
+	}
+	return runtime, err
+}
+
+// startVendors starts the vendors that aren't running and waits for them to
+// be ready, it tells if all of them are up
+func startVendors(runtime vendors.Runtime, vendorList []*vendors.Vendor) bool {
+	success := true
+	started := make([]*vendors.Vendor, 0, len(vendorList))
+	for _, vendor := range vendorList {
+		spacing := strings.Repeat(" ", services.MaxServiceNameLength+2-len(vendor.Name))
+		status, err := runtime.Status(vendor)
+		if err == nil && status.Running {
+			terminal.Stdout.Colorf("%s%s| @{c} already running\n", vendor.Name, spacing)
+			started = append(started, vendor)
+			continue
+		}
+		if err == nil {
+			err = runtime.Start(vendor)
+		}
+		if err != nil {
+			success = false
+			appendError(err)
+			terminal.Stdout.Colorf("%s%s| @{r} error: @{|}%v\n", vendor.Name, spacing, err)
+			continue
+		}
+		terminal.Stdout.Colorf("%s%s| @{g} started@{|} (%s)\n", vendor.Name, spacing, runtime.Name())
+		started = append(started, vendor)
+	}
+
+	// The services may connect to the vendors as soon as they start
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for _, vendor := range started {
+		wg.Add(1)
+		go func(vendor *vendors.Vendor) {
+			defer wg.Done()
+			if err := waitVendor(runtime, vendor); err != nil {
+				mu.Lock()
+				success = false
+				mu.Unlock()
+				appendError(err)
+				spacing := strings.Repeat(" ", services.MaxServiceNameLength+2-len(vendor.Name))
+				terminal.Stdout.Colorf("%s%s| @{r} error: @{|}%v\n", vendor.Name, spacing, err)
+			}
+		}(vendor)
+	}
+	wg.Wait()
+	return success
+}
+
+// waitVendor waits up to the ready timeout of a vendor for it to be ready
+func waitVendor(runtime vendors.Runtime, vendor *vendors.Vendor) error {
+	deadline := time.Now().Add(vendor.ReadyTimeout)
+	for {
+		ready, err := runtime.Ready(vendor)
+		if err != nil {
+			return err
+		}
+		if ready {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Vendor %s is not ready after %s", vendor.Name, vendor.ReadyTimeout)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// printVendors outputs a ps line for every vendor
+func printVendors(runtime vendors.Runtime, vendorList []*vendors.Vendor) {
+	for _, vendor := range vendorList {
+		spacing := strings.Repeat(" ", services.MaxServiceNameLength+2-len(vendor.Name))
+		status, err := runtime.Status(vendor)
+		switch {
+		case err != nil:
+			appendError(err)
+			terminal.Stdout.Colorf("@{r}%s", vendor.Name).Reset().Colorf("%s| @{r} error: @{|}%v\n", spacing, err)
+		case status.Running:
+			id := status.ID
+			if len(id) > 12 {
+				id = id[:12]
+			}
+			terminal.Stdout.Colorf("@{g}%s", vendor.Name).Reset().Colorf("%s|", spacing).Print(" running ").Colorf("  %s  %s  (vendor, %s)\n", id, strings.Join(status.Ports, " "), vendor.Image)
+		case status.Exists:
+			terminal.Stdout.Colorf("@{r}%s", vendor.Name).Reset().Colorf("%s|", spacing).Print(" "+status.State).Colorf("  (vendor, %s)\n", vendor.Image)
+		default:
+			terminal.Stdout.Colorf("@{r}%s", vendor.Name).Reset().Colorf("%s|", spacing).Print(" down").Colorf("  (vendor, %s)\n", vendor.Image)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"github.com/wsxiaoys/terminal"
+
+	"github.com/tifo/orchestra/services"
+)
+
+var WaitCommand = &cli.Command{
+	Name:         "wait",
+	Usage:        "Waits for service(s) to be running and healthy",
+	Action:       BeforeAfterWrapper(WaitAction),
+	BashComplete: ServicesBashComplete,
+	Flags: []cli.Flag{
+		&cli.DurationFlag{
+			Name:  "timeout",
+			Usage: "How long to wait for a service to be running",
+			Value: time.Minute,
+		},
+	},
+}
+
+// WaitAction blocks until every instance of every service (or the specified
+// ones) is healthy, so that scripts can rely on them being ready
+func WaitAction(c *cli.Context) error {
+	wg := &sync.WaitGroup{}
+	for _, service := range FilterServices(c) {
+		for _, instance := range service.Instances(service.Scale) {
+			wg.Add(1)
+			go func(instance *services.Service) {
+				defer wg.Done()
+				spacing := strings.Repeat(" ", services.MaxServiceNameLength+2-len(instance.Name))
+				err := waitForService(c, instance, c.Duration("timeout"))
+				if err != nil {
+					appendError(err)
+					terminal.Stdout.Colorf("%s%s| @{r} error: @{|}%v\n", instance.Name, spacing, err)
+				} else {
+					terminal.Stdout.Colorf("%s%s| @{g} ready\n", instance.Name, spacing)
+				}
+			}(instance)
+		}
+	}
+	wg.Wait()
+	return nil
+}
+
+// waitForService waits up to timeout for the service to be running (e.g.
+// restarted by its supervisor), and then for its health check to pass
+func waitForService(c *cli.Context, service *services.Service, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for !service.IsRunning() {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Service %s is not running after %s", service.Name, timeout)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	return service.WaitHealthy(nil, GetEnvForService(c, service))
+}
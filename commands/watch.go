@@ -0,0 +1,201 @@
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/urfave/cli/v2"
+	"github.com/wsxiaoys/terminal"
+
+	"github.com/tifo/orchestra/services"
+)
+
+var WatchCommand = &cli.Command{
+	Name:         "watch",
+	Usage:        "Rebuilds and restarts services when their sources change",
+	Action:       BeforeAfterWrapper(WatchAction),
+	BashComplete: ServicesBashComplete,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "test",
+			Usage: "Run the tests before restarting, and skip the restart if they fail",
+		},
+		&cli.DurationFlag{
+			Name:  "debounce",
+			Usage: "How long to wait for changes to settle before rebuilding",
+			Value: 500 * time.Millisecond,
+		},
+	},
+}
+
+// localPackagesTemplate makes go list print the directories of the packages
+// that belong to the main module (or to a module replaced by a local
+// directory), skipping the standard library and downloaded modules
+const localPackagesTemplate = `{{if not .Standard}}{{if .Module}}{{if or .Module.Main (and .Module.Replace (not .Module.Replace.Version))}}{{.Dir}}{{end}}{{else}}{{.Dir}}{{end}}{{end}}`
+
+// WatchAction watches the package directory of every service (or the
+// specified ones) and of the local packages they import, or the whole
+// directory of exec services. Once changes settle, the affected services are
+// installed and restarted. When the build fails, the running instance is left
+// untouched.
+func WatchAction(c *cli.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	svcs := services.Sort(FilterServices(c))
+	watched := make(map[string][]*services.Service)
+	for _, service := range svcs {
+		watchService(watcher, watched, service)
+	}
+	terminal.Stdout.Colorf("@{c}Watching %d services, press Ctrl-C to stop\n", len(svcs))
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	changed := make(map[string]*services.Service)
+	// The files written by the build of an exec service, in its own
+	// directory, must not trigger another reload
+	quietUntil := make(map[string]time.Time)
+	var debounce <-chan time.Time
+	for {
+		select {
+		case <-signals:
+			return nil
+		case err := <-watcher.Errors:
+			terminal.Stdout.Colorf("@{r}watch error: @{|}%v\n", err)
+		case event := <-watcher.Events:
+			for _, service := range watched[filepath.Dir(event.Name)] {
+				if isSourceChange(c, service, event) && time.Now().After(quietUntil[service.Name]) {
+					changed[service.Name] = service
+					debounce = time.After(c.Duration("debounce"))
+				}
+			}
+		case <-debounce:
+			for _, service := range services.Sort(changed) {
+				if reload(c, service) {
+					watchService(watcher, watched, service)
+				}
+				quietUntil[service.Name] = time.Now().Add(c.Duration("debounce"))
+			}
+			changed = make(map[string]*services.Service)
+		}
+	}
+}
+
+// isSourceChange filters the events that require a rebuild: changes to any
+// file but hidden ones for exec services, otherwise changes to Go files, and
+// to test files only when tests are run
+func isSourceChange(c *cli.Context, service *services.Service, event fsnotify.Event) bool {
+	if event.Op == fsnotify.Chmod {
+		return false
+	}
+	if service.Type == services.TypeExec {
+		return !strings.HasPrefix(filepath.Base(event.Name), ".")
+	}
+	if !strings.HasSuffix(event.Name, ".go") {
+		return false
+	}
+	return c.Bool("test") || !strings.HasSuffix(event.Name, "_test.go")
+}
+
+// watchService adds the directories of the service and its local imports to
+// the watcher. It is called again after every rebuild to pick up new imports.
+func watchService(watcher *fsnotify.Watcher, watched map[string][]*services.Service, service *services.Service) {
+	dirs := localPackages
+	if service.Type == services.TypeExec {
+		dirs = serviceDirectories
+	}
+	for _, dir := range dirs(service) {
+		found := false
+		for _, s := range watched[dir] {
+			found = found || s == service
+		}
+		if found {
+			continue
+		}
+		if err := watcher.Add(dir); err != nil {
+			spacing := strings.Repeat(" ", services.MaxServiceNameLength+2-len(service.Name))
+			terminal.Stdout.Colorf("%s%s| @{r} error: @{|}can't watch %s: %v\n", service.Name, spacing, dir, err)
+			continue
+		}
+		watched[dir] = append(watched[dir], service)
+	}
+}
+
+// serviceDirectories lists the directory of an exec service and its
+// subdirectories, except hidden ones
+func serviceDirectories(service *services.Service) []string {
+	dirs := make([]string, 0)
+	_ = filepath.WalkDir(service.Path, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.IsDir() {
+			return nil
+		}
+		if path != service.Path && strings.HasPrefix(entry.Name(), ".") {
+			return filepath.SkipDir
+		}
+		dirs = append(dirs, path)
+		return nil
+	})
+	return dirs
+}
+
+// localPackages lists the directories of the service package and of the
+// local packages it depends on
+func localPackages(service *services.Service) []string {
+	dirs := []string{service.Path}
+	if service.PackageInfo != nil {
+		dirs[0] = service.PackageInfo.Dir
+	}
+	cmd := exec.Command("go", "list", "-deps", "-f", localPackagesTemplate, ".")
+	cmd.Dir = service.Path
+	output, err := cmd.Output()
+	if err != nil {
+		return dirs
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		if dir := strings.TrimSpace(scanner.Text()); dir != "" && dir != dirs[0] {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// reload runs the tests (when asked to), installs and restarts a service with
+// the build output streamed on the terminal. restart builds the service before
+// stopping it, so a failing build keeps the running instance. It tells if the
+// service was rebuilt and restarted.
+func reload(c *cli.Context, service *services.Service) bool {
+	spacing := strings.Repeat(" ", services.MaxServiceNameLength+2-len(service.Name))
+	terminal.Stdout.Colorf("%s%s| @{y} change detected\n", service.Name, spacing)
+	if c.Bool("test") {
+		success, err := testService(c, service)
+		if err != nil || !success {
+			terminal.Stdout.Colorf("%s%s| @{r} tests FAILED, not restarting\n", service.Name, spacing)
+			return false
+		}
+	}
+	// The build output streams as it comes, a failing build stops before the
+	// running instances
+	output := newPrefixWriter(os.Stdout, fmt.Sprintf("%s%s|  ", service.Name, spacing))
+	restarted := restart(c, service, output)
+	output.Flush()
+	if !restarted {
+		terminal.Stdout.Colorf("%s%s| @{y} restart failed, see the error above\n", service.Name, spacing)
+		return false
+	}
+	return true
+}
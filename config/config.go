@@ -3,10 +3,11 @@ package config
 import (
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"reflect"
 	"strings"
+	"sync"
+	"time"
 
 	log "github.com/cihub/seelog"
 	"github.com/urfave/cli/v2"
@@ -19,32 +20,69 @@ var orchestra *Config
 var ConfigPath string
 var globalEnvs []string
 
+// section is a command section, with the variables of its env files merged
+// in its env, or the error loading them
+type section struct {
+	config   ContextConfig
+	err      error
+	reported bool
+}
+
+// sections caches the command sections loaded so far, by command name
+var sections map[string]*section
+var sectionsMutex sync.Mutex
+
 type ContextConfig struct {
-	Env    map[string]string `yaml:"env,omitempty"`
-	Before []string          `yaml:"before,omitempty"`
-	After  []string          `yaml:"after,omitempty"`
+	Env     map[string]string `yaml:"env,omitempty"`
+	EnvFile EnvFiles          `yaml:"env_file,omitempty"`
+	Before  Hooks             `yaml:"before,omitempty"`
+	After   Hooks             `yaml:"after,omitempty"`
+}
+
+// VendorConfig describes a vendor (e.g. postgres, rabbitmq) running in a
+// container
+type VendorConfig struct {
+	Image        string            `yaml:"image"`
+	Ports        []string          `yaml:"ports,omitempty"`
+	Env          map[string]string `yaml:"env,omitempty"`
+	Volumes      []string          `yaml:"volumes,omitempty"`
+	Command      []string          `yaml:"command,omitempty"`
+	HealthCheck  string            `yaml:"healthcheck,omitempty"`
+	ReadyTimeout time.Duration     `yaml:"ready_timeout,omitempty"`
 }
 
 type Config struct {
 	// Global Configuration
-	Env    map[string]string `yaml:"env,omitempty"`
-	Before []string          `yaml:"before,omitempty"`
-	After  []string          `yaml:"after,omitempty"`
-	GoRun  bool              `yaml:"gorun,omitempty"`
+	Env     map[string]string `yaml:"env,omitempty"`
+	EnvFile EnvFiles          `yaml:"env_file,omitempty"`
+	Before  Hooks             `yaml:"before,omitempty"`
+	After   Hooks             `yaml:"after,omitempty"`
+	GoRun   bool              `yaml:"gorun,omitempty"`
 
 	// Stacks configuration (includes subfolders)
 	Stacks []string `yaml:"stacks,omitempty"`
 
+	// Vendors configuration, and the container runtime running them
+	Vendors map[string]VendorConfig `yaml:"vendors,omitempty"`
+	Runtime string                  `yaml:"runtime,omitempty"`
+
 	// Configuration for Commands
+	Adopt   ContextConfig `yaml:"adopt,omitempty"`
 	Build   ContextConfig `yaml:"build,omitempty"`
 	Export  ContextConfig `yaml:"export,omitempty"`
 	Install ContextConfig `yaml:"install,omitempty"`
 	Logs    ContextConfig `yaml:"logs,omitempty"`
 	Ps      ContextConfig `yaml:"ps,omitempty"`
+	Reload  ContextConfig `yaml:"reload,omitempty"`
 	Restart ContextConfig `yaml:"restart,omitempty"`
+	Run     ContextConfig `yaml:"run,omitempty"`
+	Signal  ContextConfig `yaml:"signal,omitempty"`
 	Start   ContextConfig `yaml:"start,omitempty"`
 	Stop    ContextConfig `yaml:"stop,omitempty"`
 	Test    ContextConfig `yaml:"test,omitempty"`
+	Up      ContextConfig `yaml:"up,omitempty"`
+	Wait    ContextConfig `yaml:"wait,omitempty"`
+	Watch   ContextConfig `yaml:"watch,omitempty"`
 }
 
 func GetBaseEnvVars() map[string]string {
@@ -55,6 +93,14 @@ func UseGoRun() bool {
 	return orchestra.GoRun
 }
 
+func GetVendors() map[string]VendorConfig {
+	return orchestra.Vendors
+}
+
+func GetRuntime() string {
+	return orchestra.Runtime
+}
+
 func GetStacks() []string {
 	if len(orchestra.Stacks) == 0 {
 		return []string{""}
@@ -69,7 +115,19 @@ func ParseGlobalConfig() {
 		_ = log.Criticalf(err.Error())
 		os.Exit(1)
 	}
-	_ = yaml.Unmarshal(b, &orchestra)
+	if err := yaml.Unmarshal(b, &orchestra); err != nil {
+		_ = log.Errorf("Error parsing %s: %s", ConfigPath, err.Error())
+	}
+
+	// The global env files are merged in the global env, the ones of the
+	// command sections only when the command runs
+	env, err := orchestra.EnvFile.Load(filepath.Dir(ConfigPath), orchestra.Env)
+	if err != nil {
+		_ = log.Criticalf("Error loading env_file in %s: %s", ConfigPath, err.Error())
+		os.Exit(1)
+	}
+	orchestra.Env = env
+	sections = make(map[string]*section)
 
 	globalEnvs = os.Environ()
 	for k, v := range orchestra.Env {
@@ -78,13 +136,98 @@ func ParseGlobalConfig() {
 }
 
 func GetEnvForCommand(c *cli.Context) []string {
+	return GetEnvForCommandName(commandPath(c))
+}
+
+// GetEnvForCommandName returns the environment of a command from its name,
+// e.g. the one services are started with for "start"
+func GetEnvForCommandName(name string) []string {
 	envs := globalEnvs
-	for k, v := range getConfigFieldByName(c.Command.Name).Env {
+	for k, v := range commandSection(name).Env {
 		envs = append(envs, fmt.Sprintf("%s=%s", k, v))
 	}
 	return envs
 }
 
+// GetEnvVarsForCommand returns the variables orchestra.yml sets for a command,
+// globally or in its own section, without the environment of orchestra
+func GetEnvVarsForCommand(c *cli.Context) map[string]string {
+	return GetEnvVarsForCommandName(commandPath(c))
+}
+
+// GetEnvVarsForCommandName is GetEnvVarsForCommand from the name of the
+// command
+func GetEnvVarsForCommandName(name string) map[string]string {
+	env := make(map[string]string)
+	for k, v := range orchestra.Env {
+		env[k] = v
+	}
+	for k, v := range commandSection(name).Env {
+		env[k] = v
+	}
+	return env
+}
+
+// GetEnvFilesForCommand returns the paths of the env files loaded for a
+// command, globally or in its own section
+func GetEnvFilesForCommand(c *cli.Context) []string {
+	return GetEnvFilesForCommandName(commandPath(c))
+}
+
+// GetEnvFilesForCommandName is GetEnvFilesForCommand from the name of the
+// command
+func GetEnvFilesForCommandName(name string) []string {
+	dir := filepath.Dir(ConfigPath)
+	return append(orchestra.EnvFile.Paths(dir), getConfigFieldByName(name).EnvFile.Paths(dir)...)
+}
+
+// LoadCommandEnv loads the env files of the section of the command, so that
+// a broken one only aborts the commands using it
+func LoadCommandEnv(c *cli.Context) error {
+	return LoadEnvForCommandName(commandPath(c))
+}
+
+// LoadEnvForCommandName is LoadCommandEnv from the name of the command, for
+// commands using the environment of another one
+func LoadEnvForCommandName(name string) error {
+	sectionsMutex.Lock()
+	defer sectionsMutex.Unlock()
+	loaded := loadSection(name)
+	loaded.reported = true
+	return loaded.err
+}
+
+// commandSection returns the section of a command with its env files loaded.
+// When they can't be, the error is logged once and the section is used with
+// its inline env only.
+func commandSection(name string) ContextConfig {
+	sectionsMutex.Lock()
+	defer sectionsMutex.Unlock()
+	loaded := loadSection(name)
+	if loaded.err != nil && !loaded.reported {
+		_ = log.Error(loaded.err.Error())
+		loaded.reported = true
+	}
+	return loaded.config
+}
+
+// loadSection loads a section the first time it is used, sectionsMutex must
+// be held
+func loadSection(name string) *section {
+	if loaded, ok := sections[name]; ok {
+		return loaded
+	}
+	loaded := &section{config: getConfigFieldByName(name)}
+	env, err := loaded.config.EnvFile.Load(filepath.Dir(ConfigPath), loaded.config.Env)
+	if err != nil {
+		loaded.err = fmt.Errorf("Error loading env_file of %s in %s: %v", name, ConfigPath, err)
+	} else {
+		loaded.config.Env = env
+	}
+	sections[name] = loaded
+	return loaded
+}
+
 // If a config file is specified, return it, otherwise try to find the nearest
 // defaultConfigFile in parents
 func FindProjectConfig(config string) string {
@@ -111,32 +254,19 @@ func FindProjectConfig(config string) string {
 	return defaultConfigFile
 }
 
-func runCommands(c *cli.Context, cmds []string) error {
-	for _, command := range cmds {
-		cmdLine := strings.Split(command, " ")
-		cmd := exec.Command(cmdLine[0], cmdLine[1:]...)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		cmd.Env = GetEnvForCommand(c)
-		err := cmd.Start()
-		if err != nil {
-			return err
-		}
-		_ = cmd.Wait()
-		if !cmd.ProcessState.Success() {
-			return fmt.Errorf("Command %s exited with error", cmdLine[0])
-		}
-	}
-	return nil
+// runHooks runs project hooks from the project directory, with the
+// environment of the command
+func runHooks(c *cli.Context, hooks Hooks) error {
+	return hooks.Run(GetEnvForCommand(c), filepath.Dir(ConfigPath), os.Stdout)
 }
 
 func GetBeforeFunc() func(c *cli.Context) error {
 	return func(c *cli.Context) error {
-		err := runCommands(c, orchestra.Before)
+		err := runHooks(c, orchestra.Before)
 		if err != nil {
 			return err
 		}
-		err = runCommands(c, getConfigFieldByName(c.Command.Name).Before)
+		err = runHooks(c, getConfigFieldByName(commandPath(c)).Before)
 		if err != nil {
 			return err
 		}
@@ -146,11 +276,11 @@ func GetBeforeFunc() func(c *cli.Context) error {
 
 func GetAfterFunc() func(c *cli.Context) error {
 	return func(c *cli.Context) error {
-		err := runCommands(c, orchestra.After)
+		err := runHooks(c, orchestra.After)
 		if err != nil {
 			return err
 		}
-		err = runCommands(c, getConfigFieldByName(c.Command.Name).After)
+		err = runHooks(c, getConfigFieldByName(commandPath(c)).After)
 		if err != nil {
 			return err
 		}
@@ -158,9 +288,35 @@ func GetAfterFunc() func(c *cli.Context) error {
 	}
 }
 
+// commandPath returns the names of the command run and of its parents, e.g.
+// "vendors ps", without the one of orchestra
+func commandPath(c *cli.Context) string {
+	names := []string{}
+	for _, ctx := range c.Lineage() {
+		if ctx.Command != nil {
+			names = append([]string{ctx.Command.Name}, names...)
+		}
+	}
+	// The first one is the root command, named after the application
+	if len(names) > 0 {
+		names = names[1:]
+	}
+	return strings.Join(names, " ")
+}
+
+// getConfigFieldByName returns the section of a command. Only top level
+// commands have one: subcommands don't use the section of their namesake.
 func getConfigFieldByName(name string) ContextConfig {
+	if name == "" || strings.Contains(name, " ") {
+		return ContextConfig{}
+	}
 	initial := strings.Split(name, "")[0]
 	value := reflect.ValueOf(orchestra)
 	f := reflect.Indirect(value).FieldByName(strings.Replace(name, initial, strings.ToUpper(initial), 1))
-	return f.Interface().(ContextConfig)
+	// Internal commands don't have a configuration section
+	if !f.IsValid() {
+		return ContextConfig{}
+	}
+	cfg, _ := f.Interface().(ContextConfig)
+	return cfg
 }
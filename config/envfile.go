@@ -0,0 +1,236 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var dotenvName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+
+// EnvFile is a dotenv file whose variables are added to an environment. It
+// is written as a path, relative to the file declaring it, which may be
+// missing when suffixed with `?`, or as a mapping:
+//
+//	env_file:
+//	    - .env
+//	    - .env.local?
+//	    - path: secrets.env
+//	      optional: true
+type EnvFile struct {
+	Path     string
+	Optional bool
+}
+
+// EnvFiles is a list of env files, a single file can be written without the
+// list
+type EnvFiles []EnvFile
+
+func (f *EnvFile) UnmarshalYAML(value *yaml.Node) error {
+	switch value.Kind {
+	case yaml.ScalarNode:
+		f.Path = value.Value
+		if strings.HasSuffix(f.Path, "?") {
+			f.Path = strings.TrimSuffix(f.Path, "?")
+			f.Optional = true
+		}
+	case yaml.MappingNode:
+		var options struct {
+			Path     string `yaml:"path"`
+			Optional bool   `yaml:"optional"`
+		}
+		if err := value.Decode(&options); err != nil {
+			return err
+		}
+		f.Path = options.Path
+		f.Optional = options.Optional
+	default:
+		return fmt.Errorf("line %d: invalid env_file", value.Line)
+	}
+	if f.Path == "" {
+		return fmt.Errorf("line %d: env_file without a path", value.Line)
+	}
+	return nil
+}
+
+func (f *EnvFiles) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind != yaml.SequenceNode {
+		var file EnvFile
+		if err := value.Decode(&file); err != nil {
+			return err
+		}
+		*f = EnvFiles{file}
+		return nil
+	}
+	files := make([]EnvFile, 0, len(value.Content))
+	if err := value.Decode(&files); err != nil {
+		return err
+	}
+	*f = files
+	return nil
+}
+
+// Paths returns the paths of the files, relative ones resolved from dir
+func (f EnvFiles) Paths(dir string) []string {
+	paths := make([]string, 0, len(f))
+	for _, file := range f {
+		path := file.Path
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// Load reads the files in order and returns their variables, overridden by
+// the ones of env: files are loaded first, later files override earlier
+// ones, and the inline variables override them all. Missing optional files
+// are skipped.
+func (f EnvFiles) Load(dir string, env map[string]string) (map[string]string, error) {
+	vars := make(map[string]string)
+	for i, path := range f.Paths(dir) {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			if f[i].Optional && errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		fileVars, err := ParseDotenv(string(b))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		for k, v := range fileVars {
+			vars[k] = v
+		}
+	}
+	for k, v := range env {
+		vars[k] = v
+	}
+	return vars, nil
+}
+
+// ParseDotenv parses the content of a dotenv file:
+//
+//	# Comments and blank lines are ignored
+//	export NAME=value          # an optional export, and inline comment
+//	LITERAL='kept $as is'
+//	ESCAPED="tab\tnewline\n \"quotes\" \$dollar"
+//	MULTILINE="first line
+//	second line"
+//
+// Unquoted values are trimmed, single quoted values are kept as is and
+// double quoted values unescape \n, \r, \t, \", \\ and \$. Quoted values may
+// span several lines. Variables are not expanded.
+func ParseDotenv(data string) (map[string]string, error) {
+	vars := make(map[string]string)
+	lines := strings.Split(strings.Replace(data, "\r\n", "\n", -1), "\n")
+	for i := 0; i < len(lines); i++ {
+		number := i + 1
+		line := strings.TrimLeft(lines[i], " \t")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "export ") || strings.HasPrefix(line, "export\t") {
+			line = strings.TrimLeft(line[len("export"):], " \t")
+		}
+		eq := strings.Index(line, "=")
+		if eq < 0 {
+			return nil, fmt.Errorf("line %d: expected NAME=value", number)
+		}
+		name := strings.TrimSpace(line[:eq])
+		if !dotenvName.MatchString(name) {
+			return nil, fmt.Errorf("line %d: invalid variable name %q", number, name)
+		}
+		value := strings.TrimLeft(line[eq+1:], " \t")
+
+		if value == "" || (value[0] != '\'' && value[0] != '"') {
+			for j := 0; j < len(value); j++ {
+				if value[j] == '#' && (j == 0 || value[j-1] == ' ' || value[j-1] == '\t') {
+					value = value[:j]
+					break
+				}
+			}
+			vars[name] = strings.TrimSpace(value)
+			continue
+		}
+
+		// Quoted values run until the closing quote, on this line or a
+		// following one
+		quote := value[0]
+		rest := value[1:]
+		quoted := &strings.Builder{}
+		for {
+			end := closingQuote(rest, quote)
+			if end >= 0 {
+				quoted.WriteString(rest[:end])
+				rest = rest[end+1:]
+				break
+			}
+			quoted.WriteString(rest)
+			quoted.WriteString("\n")
+			i++
+			if i == len(lines) {
+				return nil, fmt.Errorf("line %d: unterminated quoted value", number)
+			}
+			rest = lines[i]
+		}
+		if trailing := strings.TrimSpace(rest); trailing != "" && !strings.HasPrefix(trailing, "#") {
+			return nil, fmt.Errorf("line %d: unexpected %q after the quoted value", number, trailing)
+		}
+		value = quoted.String()
+		if quote == '"' {
+			value = unescapeDotenv(value)
+		}
+		vars[name] = value
+	}
+	return vars, nil
+}
+
+// closingQuote returns the index of the closing quote, skipping the
+// characters escaped in double quotes, or -1
+func closingQuote(s string, quote byte) int {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if quote == '"' {
+				i++
+			}
+		case quote:
+			return i
+		}
+	}
+	return -1
+}
+
+func unescapeDotenv(value string) string {
+	unescaped := &strings.Builder{}
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' || i+1 == len(value) {
+			unescaped.WriteByte(value[i])
+			continue
+		}
+		i++
+		switch value[i] {
+		case 'n':
+			unescaped.WriteByte('\n')
+		case 'r':
+			unescaped.WriteByte('\r')
+		case 't':
+			unescaped.WriteByte('\t')
+		case '"', '\\', '$':
+			unescaped.WriteByte(value[i])
+		default:
+			unescaped.WriteByte('\\')
+			unescaped.WriteByte(value[i])
+		}
+	}
+	return unescaped.String()
+}
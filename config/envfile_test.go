@@ -0,0 +1,164 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestParseDotenv(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want map[string]string
+	}{
+		{
+			name: "comments and blank lines",
+			data: "# comment\n\n   \n  # indented comment\nNAME=value\n",
+			want: map[string]string{"NAME": "value"},
+		},
+		{
+			name: "export and inline comment",
+			data: "export NAME=value          # an optional export, and inline comment",
+			want: map[string]string{"NAME": "value"},
+		},
+		{
+			name: "unquoted values are trimmed",
+			data: "TOKEN = abc#notcomment  # comment\nEMPTY=\nHASH=#gone\nDOTTED.NAME=1",
+			want: map[string]string{"TOKEN": "abc#notcomment", "EMPTY": "", "HASH": "", "DOTTED.NAME": "1"},
+		},
+		{
+			name: "single quotes keep the value as is",
+			data: `LITERAL='kept $as is' # comment`,
+			want: map[string]string{"LITERAL": "kept $as is"},
+		},
+		{
+			name: "double quotes unescape",
+			data: `ESCAPED="tab\tnewline\n \"quotes\" \$dollar \\ \z"`,
+			want: map[string]string{"ESCAPED": "tab\tnewline\n \"quotes\" $dollar \\ \\z"},
+		},
+		{
+			name: "multiline",
+			data: "MULTILINE=\"first line\nsecond line\"\nSINGLE='a\r\nb'\nNEXT=1",
+			want: map[string]string{"MULTILINE": "first line\nsecond line", "SINGLE": "a\nb", "NEXT": "1"},
+		},
+		{
+			name: "later variables override earlier ones",
+			data: "NAME=first\nNAME=second",
+			want: map[string]string{"NAME": "second"},
+		},
+		{
+			name: "variables are not expanded",
+			data: "A=1\nB=$A\nC=\"${A}\"",
+			want: map[string]string{"A": "1", "B": "$A", "C": "${A}"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseDotenv(tt.data)
+			if err != nil {
+				t.Fatalf("ParseDotenv() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseDotenv() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDotenvErrors(t *testing.T) {
+	tests := []struct {
+		data string
+		err  string
+	}{
+		{"A=1\nNOVALUE", "line 2: expected NAME=value"},
+		{"1NAME=value", `line 1: invalid variable name "1NAME"`},
+		{"MY-NAME=value", `line 1: invalid variable name "MY-NAME"`},
+		{"A=1\nB=\"open\nstill open", "line 2: unterminated quoted value"},
+		{"A='it''s'", `line 1: unexpected "'s'" after the quoted value`},
+	}
+	for _, tt := range tests {
+		_, err := ParseDotenv(tt.data)
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("ParseDotenv(%q) error = %v, want %q", tt.data, err, tt.err)
+		}
+	}
+}
+
+func TestEnvFilesUnmarshalYAML(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want EnvFiles
+	}{
+		{
+			name: "single file",
+			yaml: "env_file: .env",
+			want: EnvFiles{{Path: ".env"}},
+		},
+		{
+			name: "optional single file",
+			yaml: "env_file: .env.local?",
+			want: EnvFiles{{Path: ".env.local", Optional: true}},
+		},
+		{
+			name: "list",
+			yaml: "env_file:\n  - .env\n  - .env.local?\n  - path: secrets.env\n    optional: true\n  - path: required.env",
+			want: EnvFiles{
+				{Path: ".env"},
+				{Path: ".env.local", Optional: true},
+				{Path: "secrets.env", Optional: true},
+				{Path: "required.env"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cfg ContextConfig
+			if err := yaml.Unmarshal([]byte(tt.yaml), &cfg); err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			if !reflect.DeepEqual(cfg.EnvFile, tt.want) {
+				t.Errorf("EnvFile = %+v, want %+v", cfg.EnvFile, tt.want)
+			}
+		})
+	}
+
+	for _, invalid := range []string{"env_file: [[.env]]", "env_file:\n  - optional: true"} {
+		var cfg ContextConfig
+		if err := yaml.Unmarshal([]byte(invalid), &cfg); err == nil {
+			t.Errorf("Unmarshal(%q) = %+v, want an error", invalid, cfg.EnvFile)
+		}
+	}
+}
+
+func TestEnvFilesLoad(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(".env", "A=env\nB=env\nC=env")
+	write(".env.local", "B=local")
+
+	files := EnvFiles{{Path: ".env"}, {Path: ".env.local"}, {Path: "missing.env", Optional: true}}
+	got, err := files.Load(dir, map[string]string{"C": "inline"})
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if want := map[string]string{"A": "env", "B": "local", "C": "inline"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Load() = %v, want %v", got, want)
+	}
+
+	if _, err := (EnvFiles{{Path: "missing.env"}}).Load(dir, nil); err == nil {
+		t.Error("Load() of a missing file succeeded, want an error")
+	}
+	write("broken.env", "NOVALUE")
+	if _, err := (EnvFiles{{Path: "broken.env", Optional: true}}).Load(dir, nil); err == nil {
+		t.Error("Load() of an optional invalid file succeeded, want an error")
+	}
+}
@@ -0,0 +1,167 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Failure policies of a hook
+const (
+	OnFailureAbort    = "abort"
+	OnFailureContinue = "continue"
+)
+
+// Hook is a command run before or after something. It is written either as
+// a shell string, run with `/bin/sh -c` so that it behaves the same whatever
+// the shell of the user, as an argv list run as is, or as a mapping with
+// options:
+//
+//	before:
+//	    - echo "hello world" | tr a-z A-Z
+//	    - [go, generate, ./...]
+//	    - run: ./migrate up
+//	      timeout: 30s
+//	      workdir: db
+//	      on_failure: continue
+type Hook struct {
+	Shell     string
+	Argv      []string
+	Timeout   time.Duration
+	WorkDir   string
+	OnFailure string
+}
+
+// Hooks is a list of hooks, a single hook can be written without the list
+type Hooks []Hook
+
+func (h *Hook) UnmarshalYAML(value *yaml.Node) error {
+	switch value.Kind {
+	case yaml.ScalarNode:
+		h.Shell = value.Value
+	case yaml.SequenceNode:
+		if err := value.Decode(&h.Argv); err != nil {
+			return err
+		}
+		if len(h.Argv) == 0 {
+			return fmt.Errorf("line %d: empty hook command", value.Line)
+		}
+	case yaml.MappingNode:
+		var options struct {
+			Run       Hook          `yaml:"run"`
+			Timeout   time.Duration `yaml:"timeout"`
+			WorkDir   string        `yaml:"workdir"`
+			OnFailure string        `yaml:"on_failure"`
+		}
+		if err := value.Decode(&options); err != nil {
+			return err
+		}
+		if options.Run.Shell == "" && len(options.Run.Argv) == 0 {
+			return fmt.Errorf("line %d: hook without a run command", value.Line)
+		}
+		*h = options.Run
+		h.Timeout = options.Timeout
+		h.WorkDir = options.WorkDir
+		h.OnFailure = options.OnFailure
+	default:
+		return fmt.Errorf("line %d: invalid hook", value.Line)
+	}
+	switch h.OnFailure {
+	case "", OnFailureAbort, OnFailureContinue:
+	default:
+		return fmt.Errorf("line %d: on_failure must be %s or %s", value.Line, OnFailureAbort, OnFailureContinue)
+	}
+	return nil
+}
+
+func (h *Hooks) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind != yaml.SequenceNode {
+		var hook Hook
+		if err := value.Decode(&hook); err != nil {
+			return err
+		}
+		*h = Hooks{hook}
+		return nil
+	}
+	hooks := make([]Hook, 0, len(value.Content))
+	if err := value.Decode(&hooks); err != nil {
+		return err
+	}
+	*h = hooks
+	return nil
+}
+
+func (h Hook) String() string {
+	if h.Shell != "" {
+		return h.Shell
+	}
+	return strings.Join(h.Argv, " ")
+}
+
+// Run runs the hook from dir, or from its own workdir relative to dir. When
+// it times out, its whole process group is killed.
+func (h Hook) Run(env []string, dir string, output io.Writer) error {
+	var cmd *exec.Cmd
+	if h.Shell != "" {
+		cmd = exec.Command("/bin/sh", "-c", h.Shell)
+	} else {
+		cmd = exec.Command(h.Argv[0], h.Argv[1:]...)
+	}
+	cmd.Dir = dir
+	if h.WorkDir != "" {
+		cmd.Dir = h.WorkDir
+		if !filepath.IsAbs(h.WorkDir) {
+			cmd.Dir = filepath.Join(dir, h.WorkDir)
+		}
+	}
+	cmd.Env = env
+	cmd.Stdout = output
+	cmd.Stderr = output
+	if h.Timeout > 0 {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	var timeout <-chan time.Time
+	if h.Timeout > 0 {
+		timeout = time.After(h.Timeout)
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-timeout:
+		_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-done
+		return fmt.Errorf("timed out after %s", h.Timeout)
+	}
+}
+
+// Run runs the hooks one after the other. A failing hook stops the others,
+// unless its failure policy is to continue: the failure is then written to
+// output.
+func (hooks Hooks) Run(env []string, dir string, output io.Writer) error {
+	for _, hook := range hooks {
+		err := hook.Run(env, dir, output)
+		if err == nil {
+			continue
+		}
+		err = fmt.Errorf("`%s` failed: %v", hook, err)
+		if hook.OnFailure != OnFailureContinue {
+			return err
+		}
+		fmt.Fprintf(output, "%v, continuing\n", err)
+	}
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"time"
 
 	log "github.com/cihub/seelog"
 	"github.com/urfave/cli/v2"
@@ -12,25 +13,40 @@ import (
 	"github.com/tifo/orchestra/commands"
 	"github.com/tifo/orchestra/config"
 	"github.com/tifo/orchestra/services"
+	"github.com/tifo/orchestra/vendors"
 )
 
 var app *cli.App
 
 func main() {
+	// orchestra runs itself to apply resource limits before a service starts
+	if len(os.Args) > 1 && os.Args[1] == services.ResourcesHelper {
+		services.ExecWithResources(os.Args[2:])
+	}
 	defer log.Flush()
 	app = cli.NewApp()
 	app.Name = "Orchestra"
 	app.Usage = "Orchestrate Go Services (Tifo)"
 	app.Commands = []*cli.Command{
+		commands.AdoptCommand,
 		commands.BuildCommand,
 		commands.ExportCommand,
+		commands.ImportCommand,
 		commands.InstallCommand,
 		commands.LogsCommand,
 		commands.PsCommand,
+		commands.ReloadCommand,
 		commands.RestartCommand,
+		commands.RunCommand,
 		commands.StartCommand,
+		commands.SignalCommand,
 		commands.StopCommand,
+		commands.SuperviseCommand,
 		commands.TestCommand,
+		commands.UpCommand,
+		commands.VendorsCommand,
+		commands.WaitCommand,
+		commands.WatchCommand,
 	}
 	app.EnableBashCompletion = true
 	app.Flags = []cli.Flag{
@@ -39,6 +55,12 @@ func main() {
 			Usage:   "Specify a different config file to use (default: \"orchestra.yml\")",
 			EnvVars: []string{"ORCHESTRA_CONFIG"},
 		},
+		&cli.DurationFlag{
+			Name:    "lock-timeout",
+			Usage:   "How long to wait for a service locked by another orchestra command",
+			Value:   30 * time.Second,
+			EnvVars: []string{"ORCHESTRA_LOCK_TIMEOUT"},
+		},
 	}
 	// init checks for an existing orchestra.yml in the current working directory
 	// and creates a new .orchestra directory (if doesn't exist)
@@ -59,11 +81,13 @@ func main() {
 		}
 		config.ParseGlobalConfig()
 		services.Init()
+		vendors.Init()
 		return nil
 	}
 	app.Version = "0.6.0"
 	app.Run(os.Args)
-	if commands.HasErrors() {
-		os.Exit(1)
+	if code := commands.ExitCode(); code != 0 {
+		log.Flush()
+		os.Exit(code)
 	}
 }
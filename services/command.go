@@ -0,0 +1,95 @@
+package services
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Service types: Go packages are installed and run from their binary, exec
+// services run their command as is
+const (
+	TypeGo   = "go"
+	TypeExec = "exec"
+)
+
+// CommandLine returns the program, its arguments and the working directory to
+// run the service with. By default, the installed binary runs from the
+// project root without arguments; service.yml can change all three:
+//
+//	command: ./bin/server
+//	args: ["--config", "./dev.yaml", "--port", "$PORT"]
+//	workdir: $ORCHESTRA_PROJECT_DIR/data
+//
+// Environment variables of the service are expanded in all three, along with
+// ORCHESTRA_PROJECT_DIR and ORCHESTRA_SERVICE_DIR. A relative workdir is
+// resolved from the service directory, a relative command from the workdir.
+//
+// Services with gorun are started with `go run` instead of their binary.
+// They run from the service directory unless a workdir is set, like exec
+// services.
+func (s *Service) CommandLine(env []string) (string, []string, string) {
+	expand := func(value string) string {
+		return os.Expand(value, func(name string) string {
+			switch name {
+			case "ORCHESTRA_PROJECT_DIR":
+				return filepath.Clean(ProjectPath)
+			case "ORCHESTRA_SERVICE_DIR":
+				return s.Path
+			}
+			return lookupEnv(env, name)
+		})
+	}
+
+	dir := ProjectPath
+	if s.GoRun || s.Type == TypeExec {
+		dir = s.Path
+	}
+	if s.WorkDir != "" {
+		dir = expand(s.WorkDir)
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(s.Path, dir)
+		}
+	}
+
+	program := s.BinPath
+	if s.Command != "" {
+		program = expand(s.Command)
+		if strings.ContainsRune(program, filepath.Separator) {
+			if !filepath.IsAbs(program) {
+				program = filepath.Join(dir, program)
+			}
+		} else if path, err := exec.LookPath(program); err == nil {
+			program = path
+		}
+	}
+
+	args := make([]string, 0, len(s.Args)+2)
+	if s.GoRun && s.Command == "" {
+		program = "go"
+		if path, err := exec.LookPath(program); err == nil {
+			program = path
+		}
+		pkg := s.Path
+		if dir == s.Path {
+			pkg = "."
+		}
+		args = append(args, "run", pkg)
+	}
+	for _, arg := range s.Args {
+		args = append(args, expand(arg))
+	}
+	return program, args, dir
+}
+
+// lookupEnv returns the last value of a variable in an environment, as the
+// later entries override the earlier ones
+func lookupEnv(env []string, name string) string {
+	for i := len(env) - 1; i >= 0; i-- {
+		if strings.HasPrefix(env[i], name+"=") {
+			return strings.TrimPrefix(env[i], name+"=")
+		}
+	}
+	return ""
+}
@@ -0,0 +1,159 @@
+package services
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	log "github.com/cihub/seelog"
+)
+
+// resolveDependencies links every service to the services listed in its
+// depends_on, and reports unknown services and dependency cycles. A
+// dependency is looked up by its full name first, then inside the stack of
+// the service. Names resolving to the same service are only linked once.
+func resolveDependencies() {
+	for _, service := range Registry {
+		service.Dependencies = make([]*Service, 0, len(service.DependsOn))
+		seen := make(map[*Service]bool, len(service.DependsOn))
+		for _, name := range service.DependsOn {
+			dep, ok := Registry[name]
+			if !ok && service.Stack != "" {
+				dep, ok = Registry[path.Join(service.Stack, name)]
+			}
+			if !ok {
+				_ = log.Errorf("Service %s depends on %s, which doesn't exist", service.Name, name)
+				continue
+			}
+			if seen[dep] {
+				continue
+			}
+			seen[dep] = true
+			service.Dependencies = append(service.Dependencies, dep)
+		}
+	}
+	if cycle := findCycle(Sort(Registry)); cycle != nil {
+		_ = log.Errorf("Dependency cycle between services: %s", formatCycle(cycle))
+	}
+}
+
+// findCycle returns the services forming a dependency cycle, if any
+func findCycle(svcs []*Service) []*Service {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[*Service]int)
+	stack := make([]*Service, 0)
+
+	var visit func(s *Service) []*Service
+	visit = func(s *Service) []*Service {
+		state[s] = visiting
+		stack = append(stack, s)
+		for _, dep := range s.Dependencies {
+			switch state[dep] {
+			case visiting:
+				for i, item := range stack {
+					if item == dep {
+						return append(append([]*Service{}, stack[i:]...), dep)
+					}
+				}
+			case unvisited:
+				if cycle := visit(dep); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[s] = visited
+		return nil
+	}
+
+	for _, s := range svcs {
+		if state[s] == unvisited {
+			if cycle := visit(s); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+func formatCycle(cycle []*Service) string {
+	names := make([]string, len(cycle))
+	for i, s := range cycle {
+		names[i] = s.Name
+	}
+	return strings.Join(names, " -> ")
+}
+
+// WithDependencies returns the given services along with all their
+// dependencies, direct or not
+func WithDependencies(r map[string]*Service) map[string]*Service {
+	result := make(map[string]*Service)
+	var add func(s *Service)
+	add = func(s *Service) {
+		if _, ok := result[s.Name]; ok {
+			return
+		}
+		result[s.Name] = s
+		for _, dep := range s.Dependencies {
+			add(dep)
+		}
+	}
+	for _, s := range r {
+		add(s)
+	}
+	return result
+}
+
+// Waves sorts the services in dependency order: every wave only depends on
+// services from the previous ones, so the services of a wave can be started
+// in parallel. Dependencies outside of the given services are ignored.
+func Waves(r map[string]*Service) ([][]*Service, error) {
+	pending := make(map[string]int)
+	dependents := make(map[string][]*Service)
+	for name, s := range r {
+		pending[name] = 0
+		for _, dep := range s.Dependencies {
+			if _, ok := r[dep.Name]; ok {
+				pending[name]++
+				dependents[dep.Name] = append(dependents[dep.Name], s)
+			}
+		}
+	}
+
+	waves := make([][]*Service, 0)
+	wave := make(SortableRegistry, 0)
+	for name, count := range pending {
+		if count == 0 {
+			wave = append(wave, r[name])
+		}
+	}
+	done := 0
+	for len(wave) > 0 {
+		sort.Sort(wave)
+		waves = append(waves, wave)
+		done += len(wave)
+		next := make(SortableRegistry, 0)
+		for _, s := range wave {
+			for _, dependent := range dependents[s.Name] {
+				pending[dependent.Name]--
+				if pending[dependent.Name] == 0 {
+					next = append(next, dependent)
+				}
+			}
+		}
+		wave = next
+	}
+
+	if done != len(r) {
+		if cycle := findCycle(Sort(r)); cycle != nil {
+			return nil, fmt.Errorf("Dependency cycle between services: %s", formatCycle(cycle))
+		}
+		return nil, fmt.Errorf("Dependency cycle between services")
+	}
+	return waves, nil
+}
@@ -0,0 +1,165 @@
+package services
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// registry builds services from a map of names to depends_on lists
+func registry(deps map[string][]string) map[string]*Service {
+	r := make(map[string]*Service, len(deps))
+	for name := range deps {
+		r[name] = &Service{Name: name, Stack: stackOf(name), DependsOn: deps[name]}
+	}
+	return r
+}
+
+func stackOf(name string) string {
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		return name[:i]
+	}
+	return ""
+}
+
+// withRegistry runs resolveDependencies on r as the registry
+func withRegistry(t *testing.T, r map[string]*Service) {
+	saved := Registry
+	Registry = r
+	t.Cleanup(func() { Registry = saved })
+	resolveDependencies()
+}
+
+func dependencyNames(s *Service) []string {
+	names := make([]string, 0, len(s.Dependencies))
+	for _, dep := range s.Dependencies {
+		names = append(names, dep.Name)
+	}
+	return names
+}
+
+func TestResolveDependencies(t *testing.T) {
+	r := registry(map[string][]string{
+		"db":        nil,
+		"api/users": {"db", "missing"},
+		"api/front": {"users", "api/users"},
+	})
+	withRegistry(t, r)
+
+	tests := []struct {
+		service string
+		want    []string
+	}{
+		{"db", []string{}},
+		{"api/users", []string{"db"}},
+		{"api/front", []string{"api/users"}},
+	}
+	for _, tt := range tests {
+		if got := dependencyNames(r[tt.service]); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("dependencies of %s = %v, want %v", tt.service, got, tt.want)
+		}
+	}
+}
+
+func TestWaves(t *testing.T) {
+	tests := []struct {
+		name     string
+		deps     map[string][]string
+		selected []string
+		want     [][]string
+		err      string
+	}{
+		{
+			name: "independent services share a wave",
+			deps: map[string][]string{"a": nil, "b": nil, "c": nil},
+			want: [][]string{{"a", "b", "c"}},
+		},
+		{
+			name: "chain",
+			deps: map[string][]string{"a": {"b"}, "b": {"c"}, "c": nil},
+			want: [][]string{{"c"}, {"b"}, {"a"}},
+		},
+		{
+			name: "diamond",
+			deps: map[string][]string{"app": {"api", "worker"}, "api": {"db"}, "worker": {"db"}, "db": nil},
+			want: [][]string{{"db"}, {"api", "worker"}, {"app"}},
+		},
+		{
+			name: "missing dependency is ignored",
+			deps: map[string][]string{"a": {"nope"}, "b": {"a"}},
+			want: [][]string{{"a"}, {"b"}},
+		},
+		{
+			name:     "dependency outside the selection is ignored",
+			deps:     map[string][]string{"a": {"b"}, "b": nil},
+			selected: []string{"a"},
+			want:     [][]string{{"a"}},
+		},
+		{
+			name: "cycle",
+			deps: map[string][]string{"a": {"b"}, "b": {"c"}, "c": {"a"}, "d": nil},
+			err:  "Dependency cycle between services: a -> b -> c -> a",
+		},
+		{
+			name: "self dependency",
+			deps: map[string][]string{"a": {"a"}},
+			err:  "Dependency cycle between services: a -> a",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := registry(tt.deps)
+			withRegistry(t, r)
+			if tt.selected != nil {
+				selected := make(map[string]*Service)
+				for _, name := range tt.selected {
+					selected[name] = r[name]
+				}
+				r = selected
+			}
+
+			waves, err := Waves(r)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("Waves() error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Waves() error = %v", err)
+			}
+			got := make([][]string, 0, len(waves))
+			for _, wave := range waves {
+				names := make([]string, 0, len(wave))
+				for _, s := range wave {
+					names = append(names, s.Name)
+				}
+				got = append(got, names)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Waves() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindCycle(t *testing.T) {
+	r := registry(map[string][]string{"a": {"b"}, "b": nil})
+	withRegistry(t, r)
+	if cycle := findCycle(Sort(r)); cycle != nil {
+		t.Errorf("findCycle() = %s, want none", formatCycle(cycle))
+	}
+}
+
+func TestWithDependencies(t *testing.T) {
+	r := registry(map[string][]string{"a": {"b"}, "b": {"c"}, "c": nil, "d": nil})
+	withRegistry(t, r)
+	got := WithDependencies(map[string]*Service{"a": r["a"]})
+	names := make([]string, 0, len(got))
+	for _, s := range Sort(got) {
+		names = append(names, s.Name)
+	}
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("WithDependencies() = %v, want %v", names, want)
+	}
+}
@@ -0,0 +1,159 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultHealthInterval = time.Second
+	defaultHealthTimeout  = time.Second
+	defaultHealthRetries  = 30
+)
+
+// HealthCheck is the healthcheck block of a service.yml. Exactly one probe
+// has to be set: an HTTP GET (any 2xx/3xx response is healthy), a TCP
+// connect, a shell command exiting with 0 or a regular expression matching a
+// line of the service log.
+//
+//	healthcheck:
+//	    http: http://localhost:8080/health
+//	    interval: 1s
+//	    timeout: 2s
+//	    retries: 30
+type HealthCheck struct {
+	HTTP     string        `yaml:"http,omitempty"`
+	TCP      string        `yaml:"tcp,omitempty"`
+	Exec     string        `yaml:"exec,omitempty"`
+	Log      string        `yaml:"log,omitempty"`
+	Interval time.Duration `yaml:"interval,omitempty"`
+	Timeout  time.Duration `yaml:"timeout,omitempty"`
+	Retries  int           `yaml:"retries,omitempty"`
+
+	logRegexp *regexp.Regexp
+}
+
+func (h *HealthCheck) UnmarshalYAML(value *yaml.Node) error {
+	type plain HealthCheck
+	if err := value.Decode((*plain)(h)); err != nil {
+		return err
+	}
+	probes := 0
+	for _, probe := range []string{h.HTTP, h.TCP, h.Exec, h.Log} {
+		if probe != "" {
+			probes++
+		}
+	}
+	if probes != 1 {
+		return errors.New("healthcheck needs exactly one of http, tcp, exec or log")
+	}
+	if h.Log != "" {
+		re, err := regexp.Compile(h.Log)
+		if err != nil {
+			return fmt.Errorf("healthcheck log: %v", err)
+		}
+		h.logRegexp = re
+	}
+	if h.Interval <= 0 {
+		h.Interval = defaultHealthInterval
+	}
+	if h.Timeout <= 0 {
+		h.Timeout = defaultHealthTimeout
+	}
+	if h.Retries <= 0 {
+		h.Retries = defaultHealthRetries
+	}
+	return nil
+}
+
+// Probe runs the health check once. env is used by exec probes, and expanded
+// in the http and tcp targets so that every instance probes its own ports.
+func (h *HealthCheck) Probe(s *Service, env []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), h.Timeout)
+	defer cancel()
+	expand := func(value string) string {
+		return os.Expand(value, func(name string) string { return lookupEnv(env, name) })
+	}
+
+	switch {
+	case h.HTTP != "":
+		target := expand(h.HTTP)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			return fmt.Errorf("GET %s returned %s", target, resp.Status)
+		}
+	case h.TCP != "":
+		conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", expand(h.TCP))
+		if err != nil {
+			return err
+		}
+		conn.Close()
+	case h.Exec != "":
+		cmd := exec.CommandContext(ctx, "/bin/sh", "-c", h.Exec)
+		cmd.Dir = s.Path
+		cmd.Env = env
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("%s: %v %s", h.Exec, err, output)
+		}
+	case h.logRegexp != nil:
+		file, err := os.Open(s.LogFilePath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			if h.logRegexp.Match(scanner.Bytes()) {
+				return nil
+			}
+		}
+		return fmt.Errorf("no log line matching %q", h.Log)
+	}
+	return nil
+}
+
+// WaitHealthy probes the service every interval until it is healthy. It gives
+// up after the configured retries, as soon as the service process exits or
+// once stop is closed (a nil stop waits for the retries). Services without a
+// health check are healthy as long as they are running.
+func (s *Service) WaitHealthy(stop <-chan struct{}, env []string) error {
+	if s.HealthCheck == nil {
+		if !s.IsRunning() {
+			return fmt.Errorf("Service %s is not running", s.Name)
+		}
+		return nil
+	}
+	var err error
+	for i := 0; i < s.HealthCheck.Retries; i++ {
+		if !s.IsRunning() {
+			return fmt.Errorf("Service %s exited before becoming healthy", s.Name)
+		}
+		if err = s.HealthCheck.Probe(s, env); err == nil {
+			return nil
+		}
+		select {
+		case <-stop:
+			return fmt.Errorf("Stopped waiting for %s to be healthy", s.Name)
+		case <-time.After(s.HealthCheck.Interval):
+		}
+	}
+	return fmt.Errorf("Service %s is unhealthy after %d checks: %v", s.Name, s.HealthCheck.Retries, err)
+}
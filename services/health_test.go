@@ -0,0 +1,34 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWaitHealthyStops(t *testing.T) {
+	pidFilePath := filepath.Join(t.TempDir(), "test.pid")
+	if err := WriteProcessState(pidFilePath, os.Getpid(), ""); err != nil {
+		t.Fatal(err)
+	}
+	s := &Service{
+		Name:        "test",
+		Path:        t.TempDir(),
+		PidFilePath: pidFilePath,
+		HealthCheck: &HealthCheck{Exec: "false", Interval: time.Hour, Timeout: time.Second, Retries: 2},
+	}
+
+	stop := make(chan struct{})
+	close(stop)
+	done := make(chan error, 1)
+	go func() { done <- s.WaitHealthy(stop, nil) }()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("WaitHealthy succeeded with a failing health check")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("WaitHealthy did not stop once stop was closed")
+	}
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/tifo/orchestra/config"
+)
+
+// Hook names, as written in service.yml
+const (
+	HookPreStart  = "pre_start"
+	HookPostStart = "post_start"
+	HookPreStop   = "pre_stop"
+	HookPostStop  = "post_stop"
+	HookOnCrash   = "on_crash"
+)
+
+// LifecycleHooks are commands run around the lifecycle of a service. Start
+// and stop hooks run once per service, whatever its scale: pre_start after
+// the service is built, post_start once all its instances are ready,
+// pre_stop and post_stop around stopping its instances. on_crash runs
+// whenever an instance exits with an error. Each hook is one or more
+// commands, written like the project hooks.
+//
+//	pre_start: ./migrate up
+//	post_start:
+//	    - ./seed
+//	    - run: curl -X POST localhost:$PORT/warmup
+//	      timeout: 5s
+//	      on_failure: continue
+type LifecycleHooks struct {
+	PreStart  config.Hooks `yaml:"pre_start,omitempty"`
+	PostStart config.Hooks `yaml:"post_start,omitempty"`
+	PreStop   config.Hooks `yaml:"pre_stop,omitempty"`
+	PostStop  config.Hooks `yaml:"post_stop,omitempty"`
+	OnCrash   config.Hooks `yaml:"on_crash,omitempty"`
+}
+
+func (h LifecycleHooks) hooks(name string) config.Hooks {
+	switch name {
+	case HookPreStart:
+		return h.PreStart
+	case HookPostStart:
+		return h.PostStart
+	case HookPreStop:
+		return h.PreStop
+	case HookPostStop:
+		return h.PostStop
+	case HookOnCrash:
+		return h.OnCrash
+	}
+	return nil
+}
+
+// HasHook tells if the service has commands for a hook
+func (s *Service) HasHook(name string) bool {
+	return len(s.Hooks.hooks(name)) > 0
+}
+
+// RunHook runs the commands of a hook one after the other, from the working
+// directory of the service
+func (s *Service) RunHook(name string, env []string, output io.Writer) error {
+	_, _, dir := s.CommandLine(env)
+	if err := s.Hooks.hooks(name).Run(env, dir, output); err != nil {
+		return fmt.Errorf("%s hook of %s: %v", name, s.Name, err)
+	}
+	return nil
+}
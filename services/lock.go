@@ -0,0 +1,66 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Lock takes an exclusive advisory lock on the service, shared by all its
+// instances, so that concurrent orchestra processes don't start or stop it
+// at the same time. The lock file records the pid and the command of its
+// holder. When the lock is taken, waiting is called once with the holder,
+// and Lock gives up after timeout. Once locked, the state of the service is
+// read again, as the holder may have changed it. The returned function
+// releases the lock.
+func (s *Service) Lock(timeout time.Duration, command string, waiting func(holder string)) (func(), error) {
+	file, err := os.OpenFile(s.LockFilePath, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(timeout)
+	notified := false
+	for {
+		err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			file.Close()
+			return nil, err
+		}
+		holder := lockHolder(s.LockFilePath)
+		if time.Now().After(deadline) {
+			file.Close()
+			return nil, fmt.Errorf("Service %s is locked by %s", s.Name, holder)
+		}
+		if !notified && waiting != nil {
+			waiting(holder)
+			notified = true
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	_ = file.Truncate(0)
+	_, _ = file.WriteAt([]byte(fmt.Sprintf("%d\n%s\n", os.Getpid(), command)), 0)
+	s.IsRunning()
+	s.IsSupervised()
+	return func() {
+		_ = file.Truncate(0)
+		_ = syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+	}, nil
+}
+
+// lockHolder describes the process holding a lock, as recorded in the file
+func lockHolder(lockFilePath string) string {
+	b, err := os.ReadFile(lockFilePath)
+	lines := strings.SplitN(strings.TrimSpace(string(b)), "\n", 2)
+	if err != nil || len(lines) < 2 {
+		return "another orchestra process"
+	}
+	return fmt.Sprintf("pid %s running `%s`", lines[0], lines[1])
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// ProcessInfo is an entry of the system process table. Exe is only known
+// when the process was read from /proc.
+type ProcessInfo struct {
+	Pid     int
+	Ppid    int
+	Pgid    int
+	Command string
+	Exe     string
+}
+
+// Processes returns the system process table. It relies on ps rather than
+// /proc so that it works the same on Linux and macOS.
+func Processes() ([]ProcessInfo, error) {
+	output, err := exec.Command("ps", "-A", "-o", "pid=,ppid=,pgid=,comm=").Output()
+	if err != nil {
+		return nil, err
+	}
+	procs := make([]ProcessInfo, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		pid, _ := strconv.Atoi(fields[0])
+		ppid, _ := strconv.Atoi(fields[1])
+		pgid, _ := strconv.Atoi(fields[2])
+		procs = append(procs, ProcessInfo{
+			Pid:     pid,
+			Ppid:    ppid,
+			Pgid:    pgid,
+			Command: strings.Join(fields[3:], " "),
+		})
+	}
+	return procs, scanner.Err()
+}
+
+// Descendants returns every process forked by pid, directly or not, along
+// with the processes still in its process group whose parent already exited
+func Descendants(pid int) ([]ProcessInfo, error) {
+	procs, err := Processes()
+	if err != nil {
+		return nil, err
+	}
+	children := make(map[int][]ProcessInfo)
+	for _, p := range procs {
+		children[p.Ppid] = append(children[p.Ppid], p)
+	}
+
+	seen := map[int]bool{pid: true}
+	result := make([]ProcessInfo, 0)
+	queue := []int{pid}
+	for _, p := range procs {
+		if p.Pgid == pid && !seen[p.Pid] {
+			seen[p.Pid] = true
+			result = append(result, p)
+			queue = append(queue, p.Pid)
+		}
+	}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, child := range children[current] {
+			if !seen[child.Pid] {
+				seen[child.Pid] = true
+				result = append(result, child)
+				queue = append(queue, child.Pid)
+			}
+		}
+	}
+	return result, nil
+}
+
+// Children returns the processes spawned by the running service
+func (s *Service) Children() []ProcessInfo {
+	if s.Process == nil {
+		return nil
+	}
+	children, _ := Descendants(s.Process.Pid)
+	return children
+}
+
+// MainPid returns the pid of the process running the service code: the
+// binary built by `go run` for services started with it, as `go run` itself
+// doesn't relay signals
+func (s *Service) MainPid() int {
+	if s.Process == nil {
+		return 0
+	}
+	if s.GoRun && s.Command == "" {
+		for _, child := range s.Children() {
+			if child.Ppid == s.Process.Pid {
+				return child.Pid
+			}
+		}
+	}
+	return s.Process.Pid
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Resources limits what a service may use, it is configured in the
+// `resources` section of service.yml:
+//
+//	resources:
+//	  memory: 512M       # memory max (cgroup), or data segment size (rlimit)
+//	  cpu: 0.5           # CPU quota, in cores (cgroup only)
+//	  nofile: 4096       # open files limit
+//	  nice: 10           # niceness
+//	  oom_score_adj: 500 # OOM killer score adjustment
+type Resources struct {
+	Memory      ByteSize `yaml:"memory"`
+	CPU         float64  `yaml:"cpu"`
+	NoFile      uint64   `yaml:"nofile"`
+	Nice        int      `yaml:"nice"`
+	OOMScoreAdj int      `yaml:"oom_score_adj"`
+}
+
+// ResourceUsage is what a running service currently uses
+type ResourceUsage struct {
+	// Memory is the memory charged to the cgroup of the service, or the sum
+	// of the resident memory of its processes
+	Memory ByteSize
+	// CPU is the number of cores used, sampled over a short period
+	CPU float64
+	// Files is the number of files opened by the service process
+	Files int
+}
+
+// ByteSize is an amount of memory, written in service.yml as a number of
+// bytes or with a binary unit suffix (e.g. 512K, 256M, 2G)
+type ByteSize uint64
+
+var byteUnits = []string{"B", "K", "M", "G", "T"}
+
+func (b *ByteSize) UnmarshalYAML(value *yaml.Node) error {
+	size, err := ParseByteSize(value.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %v", value.Line, err)
+	}
+	*b = size
+	return nil
+}
+
+// ParseByteSize parses sizes like 1024, 512K, 256MB, 2GiB or 1.5G
+func ParseByteSize(s string) (ByteSize, error) {
+	value := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B"), "I")
+	multiplier := uint64(1)
+	for i, unit := range byteUnits[1:] {
+		if strings.HasSuffix(value, unit) {
+			value = strings.TrimSuffix(value, unit)
+			multiplier = 1 << (10 * uint(i+1))
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return ByteSize(n * float64(multiplier)), nil
+}
+
+func (b ByteSize) String() string {
+	value := float64(b)
+	unit := 0
+	for value >= 1024 && unit < len(byteUnits)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%dB", uint64(b))
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(fmt.Sprintf("%.1f", value), "0"), ".") + byteUnits[unit]
+}
@@ -0,0 +1,315 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	cgroupRoot = "/sys/fs/cgroup"
+	// cpuPeriod is the cgroup CPU period, in microseconds
+	cpuPeriod = 100000
+	// clockTicks is the unit of the CPU times in /proc/<pid>/stat
+	clockTicks = 100
+)
+
+// ResourcesHelper is the argument orchestra is re-executed with to apply the
+// resource limits of a service to itself, before executing the service
+const ResourcesHelper = "__apply-resources"
+
+// resourceLimits is what the helper applies, passed as JSON
+type resourceLimits struct {
+	Group       string `json:"group,omitempty"`
+	Memory      uint64 `json:"memory,omitempty"`
+	NoFile      uint64 `json:"nofile,omitempty"`
+	Nice        int    `json:"nice,omitempty"`
+	OOMScoreAdj int    `json:"oom_score_adj,omitempty"`
+	// Status is the descriptor the helper reports errors on, it is closed
+	// on exec
+	Status int `json:"status"`
+}
+
+// LimitCommand makes cmd apply the resource limits of the service before
+// executing the service, so that nothing it forks escapes them: cmd runs
+// orchestra as a helper which joins the cgroup of the service instance, sets
+// its rlimits, niceness and OOM score adjustment, and then executes the
+// service in place. Memory and CPU go to a cgroup v2 group when orchestra can
+// create one, otherwise memory is limited with RLIMIT_DATA and the CPU quota
+// can't be enforced.
+//
+// The returned function must be called once cmd is started: it waits for
+// the helper to execute the service and returns the error it hit, if any.
+func (s *Service) LimitCommand(cmd *exec.Cmd) (func() error, error) {
+	r := s.Resources
+	if r == nil {
+		return func() error { return nil }, nil
+	}
+	limits := resourceLimits{NoFile: r.NoFile, Nice: r.Nice, OOMScoreAdj: r.OOMScoreAdj}
+	if r.Memory > 0 || r.CPU > 0 {
+		group, err := s.createCgroup()
+		if err != nil {
+			_ = log.Warnf("%s: no cgroup, CPU quota disabled and memory limited through rlimits: %v", s.Name, err)
+			limits.Memory = uint64(r.Memory)
+		}
+		limits.Group = group
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	status, statusWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.ExtraFiles = append(cmd.ExtraFiles, statusWriter)
+	limits.Status = 2 + len(cmd.ExtraFiles)
+	spec, _ := json.Marshal(limits)
+	cmd.Args = append([]string{executable, ResourcesHelper, string(spec), cmd.Path}, cmd.Args...)
+	cmd.Path = executable
+
+	return func() error {
+		statusWriter.Close()
+		defer status.Close()
+		// The helper closes the status pipe when it executes the service,
+		// or when it exits
+		message, _ := io.ReadAll(status)
+		if len(message) > 0 {
+			return errors.New(string(message))
+		}
+		return nil
+	}, nil
+}
+
+// ExecWithResources is run by the helper started by LimitCommand, with the
+// limits, the program and its arguments: it applies the limits to itself and
+// executes the program. It never returns.
+func ExecWithResources(args []string) {
+	var limits resourceLimits
+	if len(args) < 3 || json.Unmarshal([]byte(args[0]), &limits) != nil {
+		fmt.Fprintf(os.Stderr, "usage: %s <limits> <program> <argv...>\n", ResourcesHelper)
+		os.Exit(2)
+	}
+	status := os.NewFile(uintptr(limits.Status), "status")
+	syscall.CloseOnExec(limits.Status)
+	fail := func(err error) {
+		fmt.Fprint(status, err.Error())
+		os.Exit(127)
+	}
+
+	// Niceness is per thread, and execve keeps the one of the calling thread
+	runtime.LockOSThread()
+	if limits.Group != "" {
+		if err := os.WriteFile(filepath.Join(limits.Group, "cgroup.procs"), []byte("0"), 0644); err != nil {
+			fail(fmt.Errorf("can't join cgroup %s: %v", limits.Group, err))
+		}
+	}
+	if limits.Memory > 0 {
+		limit := &syscall.Rlimit{Cur: limits.Memory, Max: limits.Memory}
+		if err := syscall.Setrlimit(unix.RLIMIT_DATA, limit); err != nil {
+			fail(fmt.Errorf("can't limit memory: %v", err))
+		}
+	}
+	if limits.NoFile > 0 {
+		limit := &syscall.Rlimit{Cur: limits.NoFile, Max: limits.NoFile}
+		if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, limit); err != nil {
+			fail(fmt.Errorf("can't limit open files: %v", err))
+		}
+	}
+	if limits.Nice != 0 {
+		if err := unix.Setpriority(unix.PRIO_PROCESS, 0, limits.Nice); err != nil {
+			fail(fmt.Errorf("can't set niceness: %v", err))
+		}
+	}
+	if limits.OOMScoreAdj != 0 {
+		if err := os.WriteFile("/proc/self/oom_score_adj", []byte(strconv.Itoa(limits.OOMScoreAdj)), 0644); err != nil {
+			fail(fmt.Errorf("can't set OOM score adjustment: %v", err))
+		}
+	}
+	fail(syscall.Exec(args[1], args[2:], os.Environ()))
+}
+
+// cgroupPath returns the cgroup of the service instance: a sibling of the
+// cgroup orchestra runs in, since a cgroup v2 group with processes can't
+// have controllers enabled for its children. The project path is hashed in
+// so that projects with the same service names don't share groups.
+func (s *Service) cgroupPath() (string, error) {
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		return "", errors.New("cgroup v2 is not mounted")
+	}
+	self, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	current := ""
+	scanner := bufio.NewScanner(bytes.NewReader(self))
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.HasPrefix(line, "0::") {
+			current = strings.TrimPrefix(line, "0::")
+		}
+	}
+	if current == "" {
+		return "", errors.New("orchestra doesn't run in a cgroup v2 group")
+	}
+	project := sha256.Sum256([]byte(ProjectPath))
+	name := fmt.Sprintf("orchestra-%x-%s", project[:4], strings.TrimSuffix(filepath.Base(s.PidFilePath), ".pid"))
+	return filepath.Join(cgroupRoot, filepath.Dir(current), name), nil
+}
+
+// createCgroup creates the cgroup of the service and sets its limits, the
+// service joins it before being executed
+func (s *Service) createCgroup() (string, error) {
+	group, err := s.cgroupPath()
+	if err != nil {
+		return "", err
+	}
+	// Enabling the controllers fails when they already are, or when the
+	// parent isn't delegated to us. The limit files tell which case it is.
+	_ = os.WriteFile(filepath.Join(filepath.Dir(group), "cgroup.subtree_control"), []byte("+memory +cpu"), 0644)
+	if err := os.Mkdir(group, 0755); err != nil && !os.IsExist(err) {
+		return "", err
+	}
+	memoryMax, cpuMax := "max", fmt.Sprintf("max %d", cpuPeriod)
+	if s.Resources.Memory > 0 {
+		memoryMax = strconv.FormatUint(uint64(s.Resources.Memory), 10)
+	}
+	if s.Resources.CPU > 0 {
+		cpuMax = fmt.Sprintf("%d %d", int(s.Resources.CPU*cpuPeriod), cpuPeriod)
+	}
+	if err := os.WriteFile(filepath.Join(group, "memory.max"), []byte(memoryMax), 0644); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(group, "cpu.max"), []byte(cpuMax), 0644); err != nil {
+		return "", err
+	}
+	return group, nil
+}
+
+// ReleaseResources removes the cgroup of the service once it is stopped
+func (s *Service) ReleaseResources() {
+	if s.Resources == nil {
+		return
+	}
+	if group, err := s.cgroupPath(); err == nil {
+		_ = os.Remove(group)
+	}
+}
+
+// ResourceUsage samples what the running service and its children use
+func (s *Service) ResourceUsage(children []ProcessInfo) ResourceUsage {
+	usage := ResourceUsage{}
+	if s.Process == nil {
+		return usage
+	}
+	pids := []int{s.Process.Pid}
+	for _, child := range children {
+		pids = append(pids, child.Pid)
+	}
+	if fds, err := os.ReadDir(filepath.Join("/proc", strconv.Itoa(s.Process.Pid), "fd")); err == nil {
+		usage.Files = len(fds)
+	}
+
+	group, err := s.cgroupPath()
+	if err == nil && cgroupHas(group, s.Process.Pid) {
+		if current, err := readUint(filepath.Join(group, "memory.current")); err == nil {
+			usage.Memory = ByteSize(current)
+		}
+		before, _ := cgroupCPU(group)
+		start := time.Now()
+		time.Sleep(200 * time.Millisecond)
+		after, _ := cgroupCPU(group)
+		usage.CPU = float64(after-before) / float64(time.Since(start).Microseconds())
+		return usage
+	}
+
+	for _, pid := range pids {
+		usage.Memory += ByteSize(processRSS(pid))
+	}
+	before := processesCPU(pids)
+	start := time.Now()
+	time.Sleep(200 * time.Millisecond)
+	after := processesCPU(pids)
+	usage.CPU = float64(after-before) / clockTicks / time.Since(start).Seconds()
+	return usage
+}
+
+func cgroupHas(group string, pid int) bool {
+	procs, err := os.ReadFile(filepath.Join(group, "cgroup.procs"))
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Fields(string(procs)) {
+		if line == strconv.Itoa(pid) {
+			return true
+		}
+	}
+	return false
+}
+
+// cgroupCPU returns the CPU time used by a cgroup, in microseconds
+func cgroupCPU(group string) (uint64, error) {
+	stat, err := os.ReadFile(filepath.Join(group, "cpu.stat"))
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(stat), "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 && fields[0] == "usage_usec" {
+			return strconv.ParseUint(fields[1], 10, 64)
+		}
+	}
+	return 0, errors.New("no usage_usec in cpu.stat")
+}
+
+// processesCPU returns the CPU time (user and system) used by processes, in
+// clock ticks
+func processesCPU(pids []int) uint64 {
+	total := uint64(0)
+	for _, pid := range pids {
+		fields, err := readStat(pid)
+		if err != nil {
+			continue
+		}
+		utime, _ := strconv.ParseUint(fields[11], 10, 64)
+		stime, _ := strconv.ParseUint(fields[12], 10, 64)
+		total += utime + stime
+	}
+	return total
+}
+
+// processRSS returns the resident memory of a process, in bytes
+func processRSS(pid int) uint64 {
+	status, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "status"))
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(status), "\n") {
+		if fields := strings.Fields(line); len(fields) >= 2 && fields[0] == "VmRSS:" {
+			kb, _ := strconv.ParseUint(fields[1], 10, 64)
+			return kb * 1024
+		}
+	}
+	return 0
+}
+
+func readUint(path string) (uint64, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+}
@@ -0,0 +1,34 @@
+//go:build !linux
+
+package services
+
+import (
+	"os/exec"
+
+	log "github.com/cihub/seelog"
+)
+
+// ResourcesHelper is only used on Linux
+const ResourcesHelper = "__apply-resources"
+
+// LimitCommand ignores the resource limits of the service with a warning, as
+// orchestra can only apply them on Linux
+func (s *Service) LimitCommand(cmd *exec.Cmd) (func() error, error) {
+	if s.Resources != nil {
+		_ = log.Warnf("%s: resource limits are only supported on Linux, ignoring them", s.Name)
+	}
+	return func() error { return nil }, nil
+}
+
+// ExecWithResources is only used on Linux
+func ExecWithResources(args []string) {
+	panic("resource limits are only supported on Linux")
+}
+
+// ReleaseResources is a no-op without cgroups
+func (s *Service) ReleaseResources() {}
+
+// ResourceUsage is not available outside of Linux
+func (s *Service) ResourceUsage(children []ProcessInfo) ResourceUsage {
+	return ResourceUsage{}
+}
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Restart policies accepted in the service.yml restart block
+const (
+	RestartNever     = "never"
+	RestartOnFailure = "on-failure"
+	RestartAlways    = "always"
+)
+
+const (
+	defaultRestartBackoff    = time.Second
+	defaultRestartMaxBackoff = time.Minute
+)
+
+// RestartPolicy describes how a supervised service is restarted when it exits.
+// It can be written in service.yml either as a plain policy name
+//
+//	restart: on-failure
+//
+// or as a block
+//
+//	restart:
+//	    policy: always
+//	    max_retries: 5
+//	    backoff: 1s
+//	    max_backoff: 30s
+type RestartPolicy struct {
+	Policy     string        `yaml:"policy,omitempty"`
+	MaxRetries int           `yaml:"max_retries,omitempty"`
+	Backoff    time.Duration `yaml:"backoff,omitempty"`
+	MaxBackoff time.Duration `yaml:"max_backoff,omitempty"`
+}
+
+func (p *RestartPolicy) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		p.Policy = value.Value
+		return p.validate()
+	}
+	type plain RestartPolicy
+	if err := value.Decode((*plain)(p)); err != nil {
+		return err
+	}
+	return p.validate()
+}
+
+func (p *RestartPolicy) validate() error {
+	switch p.Policy {
+	case "", RestartNever, RestartOnFailure, RestartAlways:
+		return nil
+	}
+	return fmt.Errorf("unknown restart policy %q (expected %s, %s or %s)", p.Policy, RestartNever, RestartOnFailure, RestartAlways)
+}
+
+// ShouldRestart tells if a service that exited (successfully or not) after
+// the given number of consecutive retries has to be started again. When no
+// policy is set, supervised services are restarted on failure.
+func (p RestartPolicy) ShouldRestart(success bool, retries int) bool {
+	if p.MaxRetries > 0 && retries >= p.MaxRetries {
+		return false
+	}
+	switch p.Policy {
+	case RestartNever:
+		return false
+	case RestartAlways:
+		return true
+	default:
+		return !success
+	}
+}
+
+// Delay returns the exponential backoff to wait before the given retry,
+// starting from Backoff and capped at MaxBackoff.
+func (p RestartPolicy) Delay(retries int) time.Duration {
+	delay := p.Backoff
+	if delay <= 0 {
+		delay = defaultRestartBackoff
+	}
+	max := p.MaxBackoffOrDefault()
+	for i := 0; i < retries && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// MaxBackoffOrDefault returns the configured maximum backoff. A service
+// running longer than this is considered stable and its retries are reset.
+func (p RestartPolicy) MaxBackoffOrDefault() time.Duration {
+	if p.MaxBackoff <= 0 {
+		return defaultRestartMaxBackoff
+	}
+	return p.MaxBackoff
+}
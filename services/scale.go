@@ -0,0 +1,119 @@
+package services
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Instances returns the first n instances of the service, named svc#1,
+// svc#2, … The first instance uses the service own pid and log files, so a
+// service that is not scaled is its own single instance. When instances were
+// selected on the command line (e.g. `orchestra stop svc#2 svc#3`), only
+// those are returned.
+func (s *Service) Instances(n int) []*Service {
+	if n < 1 {
+		n = 1
+	}
+	for _, i := range s.OnlyInstances {
+		if i > n {
+			n = i
+		}
+	}
+	if n == 1 {
+		return []*Service{s}
+	}
+	instances := make([]*Service, 0, n)
+	for i := 1; i <= n; i++ {
+		if s.selected(i) {
+			instances = append(instances, s.instance(i))
+		}
+	}
+	return instances
+}
+
+// selected tells whether an instance was selected on the command line, all
+// of them are when none was
+func (s *Service) selected(i int) bool {
+	if len(s.OnlyInstances) == 0 {
+		return true
+	}
+	for _, selected := range s.OnlyInstances {
+		if selected == i {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Service) instance(i int) *Service {
+	instance := *s
+	instance.Name = fmt.Sprintf("%s#%d", s.Name, i)
+	instance.Instance = i
+	if i > 1 {
+		instance.LogFilePath = instancePath(s.LogFilePath, ".log", i)
+		instance.PidFilePath = instancePath(s.PidFilePath, ".pid", i)
+		instance.SupervisorPidFilePath = instancePath(s.SupervisorPidFilePath, ".supervisor.pid", i)
+		instance.RestartsFilePath = instancePath(s.RestartsFilePath, ".restarts", i)
+	}
+	instance.IsRunning()
+	instance.IsSupervised()
+	return &instance
+}
+
+// instancePath turns .orchestra/svc.pid into .orchestra/svc#2.pid
+func instancePath(p string, ext string, i int) string {
+	return fmt.Sprintf("%s#%d%s", strings.TrimSuffix(p, ext), i, ext)
+}
+
+// InstanceCount returns the number of instances to look after: the
+// configured scale, or more if additional instances are still running
+func (s *Service) InstanceCount() int {
+	count := s.Scale
+	matches, _ := filepath.Glob(strings.TrimSuffix(s.PidFilePath, ".pid") + "#*.pid")
+	for _, match := range matches {
+		suffix := strings.TrimPrefix(strings.TrimSuffix(match, ".pid"), strings.TrimSuffix(s.PidFilePath, ".pid")+"#")
+		if i, err := strconv.Atoi(suffix); err == nil && i > count {
+			count = i
+		}
+	}
+	return count
+}
+
+// SetScale overrides the number of instances of the service
+func (s *Service) SetScale(n int) {
+	s.Scale = n
+	updateMaxServiceNameLength(s)
+}
+
+func updateMaxServiceNameLength(s *Service) {
+	length := len(s.Name)
+	if count := s.InstanceCount(); count > 1 {
+		length += len(fmt.Sprintf("#%d", count))
+	}
+	if length > MaxServiceNameLength {
+		MaxServiceNameLength = length
+	}
+}
+
+// InstanceEnv returns the variables identifying an instance: its index in
+// ORCHESTRA_INSTANCE, and every port declared in service.yml shifted by the
+// instance offset, so that instances don't fight over the same ports
+func (s *Service) InstanceEnv() []string {
+	instance := s.Instance
+	if instance < 1 {
+		instance = 1
+	}
+	env := []string{fmt.Sprintf("ORCHESTRA_INSTANCE=%d", instance)}
+	names := make([]string, 0, len(s.DeclaredPorts))
+	for name := range s.DeclaredPorts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		env = append(env, fmt.Sprintf("%s=%d", name, s.DeclaredPorts[name]+instance-1))
+	}
+	return env
+}
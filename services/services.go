@@ -11,6 +11,7 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	log "github.com/cihub/seelog"
 	"gopkg.in/yaml.v3"
@@ -75,37 +76,102 @@ type Service struct {
 	Color       string
 
 	// Path
-	OrchestraPath string
-	LogFilePath   string
-	PidFilePath   string
-	BinPath       string
+	OrchestraPath         string
+	LogFilePath           string
+	PidFilePath           string
+	SupervisorPidFilePath string
+	RestartsFilePath      string
+	LockFilePath          string
+	BinPath               string
 
 	// Process, Service and Package information
 	FileInfo    fs.DirEntry
 	PackageInfo *build.Package
 	Process     *os.Process
 	Env         []string
+	EnvFiles    []string
 	Ports       string
+
+	// Supervision
+	Restart    RestartPolicy
+	Supervisor *os.Process
+	Restarts   int
+
+	// Stop behaviour, and the signal reloading the service configuration
+	StopSignal   syscall.Signal
+	StopTimeout  time.Duration
+	ReloadSignal syscall.Signal
+
+	// Readiness
+	HealthCheck *HealthCheck
+
+	// Dependencies, as listed in depends_on and resolved after discovery
+	DependsOn    []string
+	Dependencies []*Service
+
+	// Scaling: Instance is the 1-based index of this instance, OnlyInstances
+	// lists the instances selected on the command line, if any
+	Scale         int
+	Instance      int
+	OnlyInstances []int
+	DeclaredPorts map[string]int
+
+	// Resource limits, applied to every instance
+	Resources *Resources
+
+	// How the service is run, see CommandLine
+	Type    string
+	Command string
+	Args    []string
+	WorkDir string
+	GoRun   bool
+
+	// Commands replacing go install and go test for exec services
+	BuildCommand string
+	TestCommand  string
+
+	Hooks LifecycleHooks
 }
 
+// serviceConfig maps the content of a service.yml file
+type serviceConfig struct {
+	Env          map[string]string `yaml:"env,omitempty"`
+	EnvFile      config.EnvFiles   `yaml:"env_file,omitempty"`
+	Restart      RestartPolicy     `yaml:"restart,omitempty"`
+	StopSignal   string            `yaml:"stop_signal,omitempty"`
+	StopTimeout  time.Duration     `yaml:"stop_timeout,omitempty"`
+	ReloadSignal string            `yaml:"reload_signal,omitempty"`
+	HealthCheck  *HealthCheck      `yaml:"healthcheck,omitempty"`
+	DependsOn    []string          `yaml:"depends_on,omitempty"`
+	Scale        int               `yaml:"scale,omitempty"`
+	Ports        map[string]int    `yaml:"ports,omitempty"`
+	Resources    *Resources        `yaml:"resources,omitempty"`
+	Command      string            `yaml:"command,omitempty"`
+	Args         []string          `yaml:"args,omitempty"`
+	WorkDir      string            `yaml:"workdir,omitempty"`
+	GoRun        *bool             `yaml:"gorun,omitempty"`
+	Type         string            `yaml:"type,omitempty"`
+	Build        string            `yaml:"build,omitempty"`
+	Test         string            `yaml:"test,omitempty"`
+	Hooks        LifecycleHooks    `yaml:",inline"`
+}
+
+const defaultStopTimeout = 10 * time.Second
+
 func (s *Service) IsRunning() bool {
-	if _, err := os.Stat(s.PidFilePath); err == nil {
-		bytes, _ := os.ReadFile(s.PidFilePath)
-		pid, _ := strconv.Atoi(string(bytes))
-		proc, procErr := os.FindProcess(pid)
-		if procErr == nil {
-			sigError := proc.Signal(syscall.Signal(0))
-			if sigError == nil {
-				s.Process = proc
-				return true
-			} else {
-				os.Remove(s.PidFilePath)
-			}
-		}
-	} else {
-		os.Remove(s.PidFilePath)
+	s.Process = findProcess(s.PidFilePath)
+	return s.Process != nil
+}
+
+// IsSupervised checks if a supervisor process is still watching over the
+// service and loads the number of restarts it recorded
+func (s *Service) IsSupervised() bool {
+	s.Supervisor = findProcess(s.SupervisorPidFilePath)
+	s.Restarts = 0
+	if bytes, err := os.ReadFile(s.RestartsFilePath); err == nil {
+		s.Restarts, _ = strconv.Atoi(strings.TrimSpace(string(bytes)))
 	}
-	return false
+	return s.Supervisor != nil
 }
 
 func discoverStack(stack string) {
@@ -126,50 +192,121 @@ func discoverStack(stack string) {
 		if item.IsDir() && !strings.HasPrefix(serviceName, ".") {
 			serviceConfigPath := path.Join(ProjectPath, serviceName, "service.yml")
 			if _, err := os.Stat(serviceConfigPath); err == nil {
-				// Check for service.yml and try to import the package
-				pkg, err := build.ImportDir(path.Join(ProjectPath, serviceName), build.FindOnly)
+				// Parse env variable in configuration
+				var serviceConfig serviceConfig
+				b, err := os.ReadFile(serviceConfigPath)
 				if err != nil {
-					_ = log.Errorf("Error registering %s", item.Name())
-					_ = log.Error(err.Error())
+					_ = log.Criticalf(err.Error())
+					os.Exit(1)
+				}
+				if err := yaml.Unmarshal(b, &serviceConfig); err != nil {
+					_ = log.Errorf("Error parsing %s: %s", serviceConfigPath, err.Error())
+				}
+
+				var pkg *build.Package
+				switch serviceConfig.Type {
+				case "", TypeGo:
+					// Check for service.yml and try to import the package
+					pkg, err = build.ImportDir(path.Join(ProjectPath, serviceName), build.FindOnly)
+					if err != nil {
+						_ = log.Errorf("Error registering %s", item.Name())
+						_ = log.Error(err.Error())
+						continue
+					}
+				case TypeExec:
+					if serviceConfig.Command == "" {
+						_ = log.Errorf("Error registering %s: exec services need a command", item.Name())
+						continue
+					}
+				default:
+					_ = log.Errorf("Error registering %s: unknown type %q", item.Name(), serviceConfig.Type)
 					continue
 				}
 
+				fileName := strings.Replace(serviceName, "/", "_", -1)
 				service := &Service{
-					Name:          serviceName,
-					Stack:         stack,
-					Description:   "",
-					FileInfo:      item,
-					PackageInfo:   pkg,
-					OrchestraPath: OrchestraServicePath,
-					LogFilePath:   path.Join(OrchestraServicePath, strings.Replace(serviceName, "/", "_", -1)+".log"),
-					PidFilePath:   path.Join(OrchestraServicePath, strings.Replace(serviceName, "/", "_", -1)+".pid"),
-					Color:         colors[len(Registry)%len(colors)],
-					Path:          path.Join(ProjectPath, serviceName),
+					Name:                  serviceName,
+					Stack:                 stack,
+					Description:           "",
+					FileInfo:              item,
+					PackageInfo:           pkg,
+					OrchestraPath:         OrchestraServicePath,
+					LogFilePath:           path.Join(OrchestraServicePath, fileName+".log"),
+					PidFilePath:           path.Join(OrchestraServicePath, fileName+".pid"),
+					SupervisorPidFilePath: path.Join(OrchestraServicePath, fileName+".supervisor.pid"),
+					RestartsFilePath:      path.Join(OrchestraServicePath, fileName+".restarts"),
+					LockFilePath:          path.Join(OrchestraServicePath, fileName+".lock"),
+					Color:                 colors[len(Registry)%len(colors)],
+					Path:                  path.Join(ProjectPath, serviceName),
 				}
 
-				// Parse env variable in configuration
-				var serviceConfig struct {
-					Env map[string]string `yaml:"env,omitempty"`
-				}
-				b, err := os.ReadFile(serviceConfigPath)
+				env, err := serviceConfig.EnvFile.Load(service.Path, serviceConfig.Env)
 				if err != nil {
-					_ = log.Criticalf(err.Error())
-					os.Exit(1)
+					_ = log.Errorf("Error registering %s: %s", item.Name(), err.Error())
+					continue
 				}
-				_ = yaml.Unmarshal(b, &serviceConfig)
-				for k, v := range serviceConfig.Env {
+				service.EnvFiles = serviceConfig.EnvFile.Paths(service.Path)
+				for k, v := range env {
 					service.Env = append(service.Env, fmt.Sprintf("%s=%s", k, v))
 				}
+				service.Restart = serviceConfig.Restart
+				service.HealthCheck = serviceConfig.HealthCheck
+				service.DependsOn = serviceConfig.DependsOn
+				service.DeclaredPorts = serviceConfig.Ports
+				service.Resources = serviceConfig.Resources
+				service.Hooks = serviceConfig.Hooks
+				service.Type = TypeGo
+				service.Command = serviceConfig.Command
+				service.Args = serviceConfig.Args
+				service.WorkDir = serviceConfig.WorkDir
+				service.GoRun = config.UseGoRun()
+				if serviceConfig.GoRun != nil {
+					service.GoRun = *serviceConfig.GoRun
+				}
+				if serviceConfig.Type == TypeExec {
+					service.Type = TypeExec
+					service.GoRun = false
+					service.BuildCommand = serviceConfig.Build
+					service.TestCommand = serviceConfig.Test
+				}
+				service.Instance = 1
+				service.Scale = serviceConfig.Scale
+				if service.Scale < 1 {
+					service.Scale = 1
+				}
 
-				// Because I like nice logging
-				if len(serviceName) > MaxServiceNameLength {
-					MaxServiceNameLength = len(serviceName)
+				service.StopSignal = syscall.SIGTERM
+				if serviceConfig.StopSignal != "" {
+					sig, err := ParseSignal(serviceConfig.StopSignal)
+					if err != nil {
+						_ = log.Errorf("Error parsing stop_signal for %s: %s", serviceName, err.Error())
+					} else {
+						service.StopSignal = sig
+					}
 				}
+				if serviceConfig.ReloadSignal != "" {
+					sig, err := ParseSignal(serviceConfig.ReloadSignal)
+					if err != nil {
+						_ = log.Errorf("Error parsing reload_signal for %s: %s", serviceName, err.Error())
+					} else {
+						service.ReloadSignal = sig
+					}
+				}
+				service.StopTimeout = serviceConfig.StopTimeout
+				if service.StopTimeout <= 0 {
+					service.StopTimeout = defaultStopTimeout
+				}
+
+				// Because I like nice logging
+				updateMaxServiceNameLength(service)
 
-				if binPath := os.Getenv("GOBIN"); binPath != "" {
-					service.BinPath = path.Join(binPath, path.Base(serviceName))
-				} else {
-					service.BinPath = path.Join(os.Getenv("GOPATH"), "bin", path.Base(serviceName))
+				// Exec services have no binary of their own
+				if service.Type == TypeGo {
+					if binPath := os.Getenv("GOBIN"); binPath != "" {
+						service.BinPath = path.Join(binPath, path.Base(serviceName))
+					} else {
+						service.BinPath = path.Join(os.Getenv("GOPATH"), "bin", path.Base(serviceName))
+					}
 				}
 
 				// Add the service to the registry
@@ -180,6 +317,7 @@ func discoverStack(stack string) {
 				// When registering, we take care, on every run, to check
 				// if the process is still alive.
 				service.IsRunning()
+				service.IsSupervised()
 			}
 		}
 	}
@@ -187,7 +325,8 @@ func discoverStack(stack string) {
 
 // DiscoverServices walks into the project path and looks in every subdirectory
 // for the service.yml file. For every service it registers it after trying
-// to import the package using Go's build.Import package
+// to import the package using Go's build.Import package. Once every stack is
+// registered, dependencies between services are resolved.
 func DiscoverServices() {
 	for _, stack := range config.GetStacks() {
 		if stack == "" || stack == "." {
@@ -200,4 +339,5 @@ func DiscoverServices() {
 			discoverStack(stack)
 		}
 	}
+	resolveDependencies()
 }
@@ -0,0 +1,222 @@
+package services
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/cihub/seelog"
+)
+
+// ProcessState is what orchestra records in a pid file about a process, so
+// that it can tell it apart from an unrelated process reusing its pid (e.g.
+// after a reboot). StartTime is read from /proc/<pid>/stat (or ps), BinPath
+// and BinHash are only recorded for binaries executed directly.
+type ProcessState struct {
+	Pid       int    `json:"pid"`
+	StartTime uint64 `json:"start_time,omitempty"`
+	BinPath   string `json:"bin_path,omitempty"`
+	BinHash   string `json:"bin_hash,omitempty"`
+}
+
+var (
+	// verifiedHashes caches the hash checks, the running image of a process
+	// can't change without its start time changing too
+	verifiedHashes = make(map[string]bool)
+	hashMutex      sync.Mutex
+)
+
+// WriteProcessState atomically replaces a pid file with the state of a
+// process, so that a concurrent reader never sees it empty. Without the start
+// time of the process the state couldn't be verified, nothing is written then.
+func WriteProcessState(pidFilePath string, pid int, binPath string) error {
+	startTime, err := processStartTime(pid)
+	if err != nil {
+		return fmt.Errorf("Can't read the start time of pid %d: %v", pid, err)
+	}
+	state := ProcessState{Pid: pid, StartTime: startTime}
+	if binPath != "" {
+		state.BinPath = binPath
+		if resolved, err := filepath.EvalSymlinks(binPath); err == nil {
+			state.BinPath = resolved
+		}
+		state.BinHash, _ = fileHash(state.BinPath)
+	}
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmpPath := pidFilePath + ".tmp"
+	if err := os.WriteFile(tmpPath, b, 0666); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, pidFilePath)
+}
+
+// readProcessState reads a pid file, either as a process state or as a
+// bare pid written by older versions
+func readProcessState(pidFilePath string) (*ProcessState, error) {
+	b, err := os.ReadFile(pidFilePath)
+	if err != nil {
+		return nil, err
+	}
+	state := &ProcessState{}
+	if pid, err := strconv.Atoi(strings.TrimSpace(string(b))); err == nil {
+		state.Pid = pid
+	} else if err := json.Unmarshal(b, state); err != nil {
+		return nil, err
+	}
+	if state.Pid <= 0 {
+		return nil, fmt.Errorf("invalid pid %d", state.Pid)
+	}
+	return state, nil
+}
+
+// verify checks that the process running with the recorded pid is the one
+// that was recorded. A state without a start time, e.g. a bare pid written by
+// older versions, or whose start time can't be read, is unverified: the pid
+// may belong to any process. The binary checks are skipped when they can't be
+// performed on this system.
+func (st *ProcessState) verify() error {
+	if st.StartTime == 0 {
+		return fmt.Errorf("no start time recorded, pid %d can't be told apart from another process", st.Pid)
+	}
+	startTime, err := processStartTime(st.Pid)
+	if err != nil {
+		return fmt.Errorf("can't read the start time of pid %d: %v", st.Pid, err)
+	}
+	if startTime != st.StartTime {
+		return fmt.Errorf("pid %d was reused by another process", st.Pid)
+	}
+	if st.BinPath == "" {
+		return nil
+	}
+	exePath := filepath.Join("/proc", strconv.Itoa(st.Pid), "exe")
+	exe, err := os.Readlink(exePath)
+	if err != nil {
+		return nil
+	}
+	if exe = strings.TrimSuffix(exe, " (deleted)"); exe != st.BinPath {
+		return fmt.Errorf("pid %d runs %s instead of %s", st.Pid, exe, st.BinPath)
+	}
+	if st.BinHash == "" {
+		return nil
+	}
+	key := fmt.Sprintf("%d:%d:%s", st.Pid, st.StartTime, st.BinHash)
+	hashMutex.Lock()
+	defer hashMutex.Unlock()
+	if verifiedHashes[key] {
+		return nil
+	}
+	// The running image is still readable through /proc after the binary
+	// was replaced on disk
+	hash, err := fileHash(exePath)
+	if err != nil {
+		return nil
+	}
+	if hash != st.BinHash {
+		return fmt.Errorf("pid %d runs a different binary than the one started", st.Pid)
+	}
+	verifiedHashes[key] = true
+	return nil
+}
+
+// processStartTime returns the start time of a process, in clock ticks
+// after boot from the 22nd field of /proc/<pid>/stat, or in seconds since the
+// epoch from ps on systems without /proc
+func processStartTime(pid int) (uint64, error) {
+	if _, err := os.Stat("/proc/self/stat"); err != nil {
+		return psStartTime(pid)
+	}
+	fields, err := readStat(pid)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(fields[19], 10, 64)
+}
+
+// psStartTime reads the start time of a process with ps, which prints it
+// with a second precision
+func psStartTime(pid int) (uint64, error) {
+	cmd := exec.Command("ps", "-o", "lstart=", "-p", strconv.Itoa(pid))
+	cmd.Env = append(os.Environ(), "LC_ALL=C")
+	output, err := cmd.Output()
+	if err != nil {
+		return 0, err
+	}
+	start, err := time.ParseInLocation("Mon Jan _2 15:04:05 2006", strings.Join(strings.Fields(string(output)), " "), time.Local)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(start.Unix()), nil
+}
+
+// readStat returns the fields of /proc/<pid>/stat following the command
+// name, starting with the process state (the 3rd field)
+func readStat(pid int) ([]string, error) {
+	stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return nil, err
+	}
+	// The command name between parentheses may contain spaces
+	i := bytes.LastIndexByte(stat, ')')
+	if i < 0 {
+		return nil, fmt.Errorf("malformed stat for pid %d", pid)
+	}
+	fields := strings.Fields(string(stat[i+1:]))
+	if len(fields) < 20 {
+		return nil, fmt.Errorf("malformed stat for pid %d", pid)
+	}
+	return fields, nil
+}
+
+func fileHash(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// findProcess reads a pid file and returns the process if it is still alive
+// and is verified to be the process that was recorded. Otherwise the stale
+// pid file is removed.
+func findProcess(pidFilePath string) *os.Process {
+	if _, err := os.Stat(pidFilePath); err != nil {
+		return nil
+	}
+	state, err := readProcessState(pidFilePath)
+	if err != nil {
+		_ = log.Warnf("Removing stale state %s: %v", pidFilePath, err)
+		os.Remove(pidFilePath)
+		return nil
+	}
+	proc, _ := os.FindProcess(state.Pid)
+	if proc.Signal(syscall.Signal(0)) != nil {
+		os.Remove(pidFilePath)
+		return nil
+	}
+	// The process may be unrelated, it must not be signaled
+	if err := state.verify(); err != nil {
+		_ = log.Warnf("Removing stale state %s: %v (if pid %d is still the service, stop it by hand or take it over with `orchestra adopt`)", pidFilePath, err, state.Pid)
+		os.Remove(pidFilePath)
+		return nil
+	}
+	return proc
+}
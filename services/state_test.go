@@ -0,0 +1,68 @@
+package services
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestFindProcess(t *testing.T) {
+	pid := os.Getpid()
+	startTime, err := processStartTime(pid)
+	if err != nil {
+		t.Skipf("can't read the start time of the test process: %v", err)
+	}
+	state := func(st ProcessState) string {
+		b, err := json.Marshal(st)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		found   bool
+	}{
+		{"verified", state(ProcessState{Pid: pid, StartTime: startTime}), true},
+		{"legacy bare pid", strconv.Itoa(pid) + "\n", false},
+		{"no start time", state(ProcessState{Pid: pid}), false},
+		{"mismatched start time", state(ProcessState{Pid: pid, StartTime: startTime + 1}), false},
+		{"other binary", state(ProcessState{Pid: pid, StartTime: startTime, BinPath: "/nonexistent/service"}), false},
+		{"invalid pid", "0", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pidFilePath := filepath.Join(t.TempDir(), "service.pid")
+			if err := os.WriteFile(pidFilePath, []byte(tt.content), 0666); err != nil {
+				t.Fatal(err)
+			}
+			proc := findProcess(pidFilePath)
+			if found := proc != nil; found != tt.found {
+				t.Errorf("findProcess found the process = %v, want %v", found, tt.found)
+			}
+			_, err := os.Stat(pidFilePath)
+			if kept := err == nil; kept != tt.found {
+				t.Errorf("pid file kept = %v, want %v", kept, tt.found)
+			}
+		})
+	}
+}
+
+func TestWriteProcessStateWithoutStartTime(t *testing.T) {
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skip(err)
+	}
+	pidFilePath := filepath.Join(t.TempDir(), "service.pid")
+	if err := WriteProcessState(pidFilePath, cmd.Process.Pid, ""); err == nil {
+		t.Error("WriteProcessState succeeded for a process without a start time")
+	}
+	if _, err := os.Stat(pidFilePath); err == nil {
+		t.Error("WriteProcessState wrote a state which can't be verified")
+	}
+}
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// procInfo is what /proc tells about a process
+type procInfo struct {
+	ProcessInfo
+	cwd     string
+	cmdline []string
+}
+
+// readProc reads the details of a process from /proc. It fails on systems
+// without procfs (e.g. macOS), and for processes of other users.
+func readProc(pid int) (*procInfo, error) {
+	dir := filepath.Join("/proc", strconv.Itoa(pid))
+	info := &procInfo{ProcessInfo: ProcessInfo{Pid: pid}}
+	exe, err := os.Readlink(filepath.Join(dir, "exe"))
+	if err != nil {
+		return nil, err
+	}
+	// Binaries replaced by go install are reported as deleted
+	info.Exe = strings.TrimSuffix(exe, " (deleted)")
+	info.cwd, _ = os.Readlink(filepath.Join(dir, "cwd"))
+	if cmdline, err := os.ReadFile(filepath.Join(dir, "cmdline")); err == nil {
+		for _, arg := range bytes.Split(bytes.TrimRight(cmdline, "\x00"), []byte{0}) {
+			info.cmdline = append(info.cmdline, string(arg))
+		}
+	}
+	info.Command = strings.Join(info.cmdline, " ")
+	if fields, err := readStat(pid); err == nil {
+		info.Ppid, _ = strconv.Atoi(fields[1])
+		info.Pgid, _ = strconv.Atoi(fields[2])
+	}
+	return info, nil
+}
+
+// matchesService tells if a process runs the service outside of orchestra:
+// either its executable is the service binary, or it works in the service
+// directory and is a `go run`, a binary built by `go run` or a binary named
+// after the service (e.g. `./service`). Binaries built by `go test` live in
+// the same temporary directories as the ones of `go run`, so those only
+// match when their parent is a `go run`.
+func (p *procInfo) matchesService(s *Service) bool {
+	if p.Exe == s.BinPath {
+		return true
+	}
+	if p.cwd != s.Path {
+		return false
+	}
+	if p.isGoRun() {
+		return true
+	}
+	if strings.Contains(p.Exe, string(filepath.Separator)+"go-build") {
+		parent, err := readProc(p.Ppid)
+		return err == nil && parent.isGoRun()
+	}
+	return filepath.Base(p.Exe) == filepath.Base(s.Path)
+}
+
+func (p *procInfo) isGoRun() bool {
+	return filepath.Base(p.Exe) == "go" && len(p.cmdline) > 1 && p.cmdline[1] == "run"
+}
+
+// Strays returns the processes running the service without being managed
+// by orchestra, skipping the managed pids. Only the topmost process of a
+// stray tree is returned, e.g. the `go run` and not the binary it built.
+func (s *Service) Strays(managed map[int]bool) []ProcessInfo {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+	matches := make(map[int]*procInfo)
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || managed[pid] || pid == os.Getpid() {
+			continue
+		}
+		info, err := readProc(pid)
+		if err != nil {
+			continue
+		}
+		if info.matchesService(s) {
+			matches[pid] = info
+		}
+	}
+
+	strays := make([]ProcessInfo, 0)
+	for _, info := range matches {
+		if _, ok := matches[info.Ppid]; !ok {
+			strays = append(strays, info.ProcessInfo)
+		}
+	}
+	sort.Slice(strays, func(i, j int) bool { return strays[i].Pid < strays[j].Pid })
+	return strays
+}
@@ -0,0 +1,145 @@
+package vendors
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tifo/orchestra/services"
+)
+
+// cliRuntime drives docker, or podman which shares its command line
+type cliRuntime struct {
+	binary string
+}
+
+func (r *cliRuntime) Name() string {
+	return r.binary
+}
+
+func (r *cliRuntime) Start(v *Vendor) error {
+	status, err := r.Status(v)
+	if err != nil {
+		return err
+	}
+	if status.Running {
+		return nil
+	}
+	if status.Exists {
+		if _, err := r.run("rm", "-f", v.Container); err != nil {
+			return err
+		}
+	}
+	_, err = r.run(runArgs(v)...)
+	return err
+}
+
+// runArgs builds the run command line of a vendor. Containers are labelled
+// with the project, and bind mounts relative to the project are made
+// absolute.
+func runArgs(v *Vendor) []string {
+	args := []string{"run", "--detach", "--name", v.Container,
+		"--label", "orchestra.project=" + filepath.Clean(services.ProjectPath),
+		"--label", "orchestra.vendor=" + v.Name,
+	}
+	for _, port := range v.Ports {
+		args = append(args, "--publish", port)
+	}
+	if v.HealthCheck != "" {
+		args = append(args, "--health-cmd", v.HealthCheck, "--health-interval", "1s")
+	}
+	keys := make([]string, 0, len(v.Env))
+	for k := range v.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		args = append(args, "--env", k+"="+v.Env[k])
+	}
+	for _, volume := range v.Volumes {
+		if strings.HasPrefix(volume, "./") || strings.HasPrefix(volume, "../") {
+			volume = filepath.Join(services.ProjectPath, volume)
+		}
+		args = append(args, "--volume", volume)
+	}
+	args = append(args, v.Image)
+	return append(args, v.Command...)
+}
+
+func (r *cliRuntime) Stop(v *Vendor, timeout time.Duration) error {
+	if _, err := r.run("stop", "--time", strconv.Itoa(int(timeout.Seconds())), v.Container); err != nil && !isMissing(err) {
+		return err
+	}
+	if _, err := r.run("rm", "--force", v.Container); err != nil && !isMissing(err) {
+		return err
+	}
+	return nil
+}
+
+func (r *cliRuntime) Status(v *Vendor) (*Status, error) {
+	output, err := r.run("inspect", "--format", "{{.Id}} {{.State.Status}} {{.State.Running}} {{if .State.Health}}{{.State.Health.Status}}{{end}}", v.Container)
+	if isMissing(err) {
+		return &Status{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(output)
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("Unexpected %s inspect output: %s", r.binary, output)
+	}
+	status := &Status{Exists: true, ID: fields[0], State: fields[1], Running: fields[2] == "true"}
+	if len(fields) > 3 {
+		status.Health = fields[3]
+	}
+	if status.Running {
+		// Lines look like "5432/tcp -> 0.0.0.0:5432"
+		ports, _ := r.run("port", v.Container)
+		for _, line := range strings.Split(strings.TrimSpace(ports), "\n") {
+			if parts := strings.Split(line, " -> "); len(parts) == 2 {
+				status.Ports = append(status.Ports, parts[1]+"->"+parts[0])
+			}
+		}
+	}
+	return status, nil
+}
+
+func (r *cliRuntime) Ready(v *Vendor) (bool, error) {
+	status, err := r.Status(v)
+	if err != nil {
+		return false, err
+	}
+	if !status.Running {
+		return false, fmt.Errorf("Vendor %s is not running (%s)", v.Name, status.State)
+	}
+	// The proxy of the runtime may accept connections before the vendor
+	// does, the health check is more reliable
+	if v.HealthCheck != "" {
+		return status.Health == "healthy", nil
+	}
+	return portsAccept(status.Ports), nil
+}
+
+// run runs the runtime binary, the error carries its output
+func (r *cliRuntime) run(args ...string) (string, error) {
+	cmd := exec.Command(r.binary, args...)
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		if stderr.Len() > 0 {
+			return "", fmt.Errorf("%s %s failed: %s", r.binary, args[0], strings.TrimSpace(stderr.String()))
+		}
+		return "", fmt.Errorf("%s %s failed: %v", r.binary, args[0], err)
+	}
+	return stdout.String(), nil
+}
+
+// isMissing tells if a command failed because the container doesn't exist
+func isMissing(err error) bool {
+	return err != nil && strings.Contains(strings.ToLower(err.Error()), "no such")
+}
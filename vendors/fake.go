@@ -0,0 +1,99 @@
+package vendors
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakeRuntime pretends to run containers, without any daemon. Containers
+// are kept in memory, and in a state file when one is given, so that
+// successive orchestra commands see the same containers.
+type FakeRuntime struct {
+	statePath  string
+	mutex      sync.Mutex
+	containers map[string]*Status
+}
+
+// NewFakeRuntime returns a fake runtime, statePath may be empty to keep the
+// containers in memory only
+func NewFakeRuntime(statePath string) *FakeRuntime {
+	r := &FakeRuntime{statePath: statePath, containers: make(map[string]*Status)}
+	if statePath != "" {
+		if b, err := os.ReadFile(statePath); err == nil {
+			_ = json.Unmarshal(b, &r.containers)
+		}
+	}
+	return r
+}
+
+func (r *FakeRuntime) Name() string {
+	return "fake"
+}
+
+func (r *FakeRuntime) Start(v *Vendor) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if status, ok := r.containers[v.Container]; ok && status.Running {
+		return nil
+	}
+	id := sha256.Sum256([]byte(v.Container + time.Now().String()))
+	status := &Status{Exists: true, Running: true, ID: hex.EncodeToString(id[:]), State: "running"}
+	if v.HealthCheck != "" {
+		status.Health = "healthy"
+	}
+	for _, port := range v.Ports {
+		// 5432:5432 is published as 0.0.0.0:5432->5432/tcp
+		if parts := strings.Split(port, ":"); len(parts) >= 2 {
+			status.Ports = append(status.Ports, "0.0.0.0:"+parts[len(parts)-2]+"->"+parts[len(parts)-1]+"/tcp")
+		}
+	}
+	r.containers[v.Container] = status
+	return r.save()
+}
+
+func (r *FakeRuntime) Stop(v *Vendor, timeout time.Duration) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.containers, v.Container)
+	return r.save()
+}
+
+func (r *FakeRuntime) Status(v *Vendor) (*Status, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if status, ok := r.containers[v.Container]; ok {
+		copied := *status
+		return &copied, nil
+	}
+	return &Status{}, nil
+}
+
+// Ready is true for the running containers, as nothing listens on their
+// ports
+func (r *FakeRuntime) Ready(v *Vendor) (bool, error) {
+	status, err := r.Status(v)
+	if err != nil {
+		return false, err
+	}
+	if !status.Running {
+		return false, fmt.Errorf("Vendor %s is not running", v.Name)
+	}
+	return true, nil
+}
+
+func (r *FakeRuntime) save() error {
+	if r.statePath == "" {
+		return nil
+	}
+	b, err := json.Marshal(r.containers)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(r.statePath, b, 0666)
+}
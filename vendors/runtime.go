@@ -0,0 +1,76 @@
+package vendors
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tifo/orchestra/services"
+)
+
+// Runtime runs the containers of the vendors. Its methods are idempotent:
+// starting a running vendor or stopping a missing one is not an error.
+type Runtime interface {
+	// Name identifies the runtime, e.g. docker
+	Name() string
+	// Start creates and starts the container of a vendor. A stopped
+	// container is recreated, so that it picks up configuration changes.
+	Start(v *Vendor) error
+	// Stop stops the container of a vendor, waiting up to timeout for it to
+	// exit, and removes it
+	Stop(v *Vendor, timeout time.Duration) error
+	// Status inspects the container of a vendor
+	Status(v *Vendor) (*Status, error)
+	// Ready tells if a running vendor accepts connections: its health check
+	// passes, or its published ports accept connections without one
+	Ready(v *Vendor) (bool, error)
+}
+
+// portsAccept tells if every published TCP port, as listed in Status.Ports,
+// accepts connections
+func portsAccept(ports []string) bool {
+	for _, port := range ports {
+		// Ports look like 0.0.0.0:5432->5432/tcp
+		parts := strings.SplitN(port, "->", 2)
+		if len(parts) != 2 || !strings.HasSuffix(parts[1], "/tcp") {
+			continue
+		}
+		host, hostPort, err := net.SplitHostPort(parts[0])
+		if err != nil {
+			continue
+		}
+		if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+			host = "localhost"
+		}
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, hostPort), time.Second)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+	}
+	return true
+}
+
+// NewRuntime returns the runtime configured in orchestra.yml: docker,
+// podman or fake (which keeps its containers in .orchestra, without running
+// anything). When none is configured, docker is used if installed, then
+// podman.
+func NewRuntime(name string) (Runtime, error) {
+	switch name {
+	case "docker", "podman":
+		return &cliRuntime{binary: name}, nil
+	case "fake":
+		return NewFakeRuntime(filepath.Join(services.OrchestraServicePath, "vendors.fake.json")), nil
+	case "":
+		for _, binary := range []string{"docker", "podman"} {
+			if _, err := exec.LookPath(binary); err == nil {
+				return &cliRuntime{binary: binary}, nil
+			}
+		}
+		return nil, fmt.Errorf("No container runtime found, install docker or podman")
+	}
+	return nil, fmt.Errorf("Unknown container runtime %q", name)
+}
@@ -0,0 +1,96 @@
+package vendors
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/tifo/orchestra/config"
+	"github.com/tifo/orchestra/services"
+)
+
+// Vendor is a third party dependency of the services (e.g. postgres,
+// rabbitmq), running in a container
+type Vendor struct {
+	Name    string
+	Image   string
+	Ports   []string
+	Env     map[string]string
+	Volumes []string
+	Command []string
+
+	// HealthCheck is a shell command run inside the container, the vendor is
+	// ready once it succeeds. Without one, the vendor is ready once its
+	// published ports accept connections.
+	HealthCheck  string
+	ReadyTimeout time.Duration
+
+	// Container is the name of the container running the vendor, unique to
+	// the project
+	Container string
+}
+
+// Status is the state of the container of a vendor
+type Status struct {
+	// Exists is false when there is no container for the vendor
+	Exists  bool
+	Running bool
+	ID      string
+	// State is what the runtime reports (e.g. running, exited)
+	State string
+	// Health is the status of the health check (starting, healthy or
+	// unhealthy), empty without one
+	Health string
+	Ports  []string
+}
+
+const defaultReadyTimeout = time.Minute
+
+// Registry contains the vendors of the project
+var Registry map[string]*Vendor
+
+var invalidContainerChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+
+// Init registers the vendors declared in orchestra.yml
+func Init() {
+	Registry = make(map[string]*Vendor)
+	project := invalidContainerChars.ReplaceAllString(filepath.Base(filepath.Clean(services.ProjectPath)), "_")
+	for name, cfg := range config.GetVendors() {
+		if cfg.Image == "" {
+			_ = log.Errorf("Error registering vendor %s: no image", name)
+			continue
+		}
+		Registry[name] = &Vendor{
+			Name:         name,
+			Image:        cfg.Image,
+			Ports:        cfg.Ports,
+			Env:          cfg.Env,
+			Volumes:      cfg.Volumes,
+			Command:      cfg.Command,
+			HealthCheck:  cfg.HealthCheck,
+			ReadyTimeout: cfg.ReadyTimeout,
+			Container:    fmt.Sprintf("orchestra-%s-%s", project, invalidContainerChars.ReplaceAllString(name, "_")),
+		}
+		if Registry[name].ReadyTimeout <= 0 {
+			Registry[name].ReadyTimeout = defaultReadyTimeout
+		}
+		// Vendors are listed along with the services
+		if len(name) > services.MaxServiceNameLength {
+			services.MaxServiceNameLength = len(name)
+		}
+	}
+}
+
+// Sort returns the vendors sorted by name
+func Sort(r map[string]*Vendor) []*Vendor {
+	sorted := make([]*Vendor, 0, len(r))
+	for _, v := range r {
+		sorted = append(sorted, v)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	return sorted
+}
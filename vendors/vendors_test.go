@@ -0,0 +1,158 @@
+package vendors
+
+import (
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tifo/orchestra/services"
+)
+
+func postgres() *Vendor {
+	return &Vendor{
+		Name:      "pg",
+		Image:     "postgres:16",
+		Ports:     []string{"5432:5432", "127.0.0.1:8080:80"},
+		Container: "orchestra-project-pg",
+	}
+}
+
+func TestFakeRuntime(t *testing.T) {
+	r := NewFakeRuntime("")
+	v := postgres()
+
+	status, err := r.Status(v)
+	if err != nil || status.Exists || status.Running {
+		t.Fatalf("Status() before Start = %+v, %v, want a missing container", status, err)
+	}
+
+	if err := r.Start(v); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	started, _ := r.Status(v)
+	if !started.Exists || !started.Running || started.State != "running" || started.ID == "" {
+		t.Fatalf("Status() after Start = %+v, want a running container", started)
+	}
+	if want := []string{"0.0.0.0:5432->5432/tcp", "0.0.0.0:8080->80/tcp"}; !reflect.DeepEqual(started.Ports, want) {
+		t.Errorf("Ports = %v, want %v", started.Ports, want)
+	}
+
+	// Starting a running vendor keeps its container
+	if err := r.Start(v); err != nil {
+		t.Fatalf("second Start() error = %v", err)
+	}
+	if again, _ := r.Status(v); again.ID != started.ID {
+		t.Errorf("second Start() recreated the container: %s, was %s", again.ID, started.ID)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := r.Stop(v, time.Second); err != nil {
+			t.Fatalf("Stop() #%d error = %v", i+1, err)
+		}
+		if status, _ := r.Status(v); status.Exists {
+			t.Fatalf("Status() after Stop #%d = %+v, want a missing container", i+1, status)
+		}
+	}
+}
+
+func TestFakeRuntimeState(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "vendors.fake.json")
+	v := postgres()
+	if err := NewFakeRuntime(statePath).Start(v); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	// Another orchestra command sees the container
+	r := NewFakeRuntime(statePath)
+	status, err := r.Status(v)
+	if err != nil || !status.Running {
+		t.Fatalf("Status() from the state file = %+v, %v, want a running container", status, err)
+	}
+	if err := r.Stop(v, time.Second); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	if status, _ := NewFakeRuntime(statePath).Status(v); status.Exists {
+		t.Errorf("Status() after Stop = %+v, want a missing container", status)
+	}
+}
+
+func TestRunArgs(t *testing.T) {
+	saved := services.ProjectPath
+	services.ProjectPath = "/src/project/"
+	t.Cleanup(func() { services.ProjectPath = saved })
+
+	v := postgres()
+	v.Env = map[string]string{"POSTGRES_USER": "app", "POSTGRES_DB": "app"}
+	v.Volumes = []string{"./data:/var/lib/postgresql/data", "../shared:/shared", "pgdata:/backups"}
+	v.Command = []string{"postgres", "-c", "fsync=off"}
+	v.HealthCheck = "pg_isready -U app"
+
+	want := []string{"run", "--detach", "--name", "orchestra-project-pg",
+		"--label", "orchestra.project=/src/project",
+		"--label", "orchestra.vendor=pg",
+		"--publish", "5432:5432",
+		"--publish", "127.0.0.1:8080:80",
+		"--health-cmd", "pg_isready -U app", "--health-interval", "1s",
+		"--env", "POSTGRES_DB=app",
+		"--env", "POSTGRES_USER=app",
+		"--volume", "/src/project/data:/var/lib/postgresql/data",
+		"--volume", "/src/shared:/shared",
+		"--volume", "pgdata:/backups",
+		"postgres:16", "postgres", "-c", "fsync=off",
+	}
+	if got := runArgs(v); !reflect.DeepEqual(got, want) {
+		t.Errorf("runArgs() =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestNewRuntime(t *testing.T) {
+	// docker and podman share the same command line
+	for _, name := range []string{"docker", "podman"} {
+		r, err := NewRuntime(name)
+		if err != nil {
+			t.Fatalf("NewRuntime(%q) error = %v", name, err)
+		}
+		if cli, ok := r.(*cliRuntime); !ok || cli.binary != name || r.Name() != name {
+			t.Errorf("NewRuntime(%q) = %#v, want the %s command line", name, r, name)
+		}
+	}
+	if _, err := NewRuntime("rkt"); err == nil {
+		t.Error("NewRuntime(\"rkt\") succeeded, want an unknown runtime error")
+	}
+}
+
+func TestPortsAccept(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	_, open, _ := net.SplitHostPort(listener.Addr().String())
+	closedListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, closed, _ := net.SplitHostPort(closedListener.Addr().String())
+	closedListener.Close()
+
+	tests := []struct {
+		name  string
+		ports []string
+		want  bool
+	}{
+		{"no ports", nil, true},
+		{"open port on all interfaces", []string{"0.0.0.0:" + open + "->5432/tcp"}, true},
+		{"open port on localhost", []string{"127.0.0.1:" + open + "->5432/tcp"}, true},
+		{"closed port", []string{"0.0.0.0:" + open + "->5432/tcp", "0.0.0.0:" + closed + "->80/tcp"}, false},
+		{"udp ports are skipped", []string{"0.0.0.0:" + closed + "->53/udp"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := portsAccept(tt.ports); got != tt.want {
+				t.Errorf("portsAccept(%q) = %v, want %v", tt.ports, got, tt.want)
+			}
+		})
+	}
+}